@@ -0,0 +1,74 @@
+package gogogo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lth-go/gogogo/stdlib"
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// 内置函数
+//////////////////////////////
+
+// loadBuiltins 定义内置函数
+func (in *Interpreter) loadBuiltins() {
+	in.env.Define("print", vm.Func(in.builtinPrint))
+	stdlib.DefineBuiltins(in.env)
+}
+
+// ImportStdlib 定义标准库函数, 见 stdlib 包
+func (in *Interpreter) ImportStdlib() {
+	stdlib.Import(in.env)
+}
+
+// builtinSignatures 内置函数的签名, 第二行起是说明
+var builtinSignatures = map[string]string{
+	"print": "print(args...)\n打印参数到 Stdout",
+}
+
+// Signature 全局函数的签名, 内置函数带说明, Go 函数按它的类型生成
+func (in *Interpreter) Signature(name string) (string, bool) {
+	rv, err := in.env.Get(name)
+	if err != nil {
+		return "", false
+	}
+	if sig, ok := builtinSignatures[name]; ok {
+		return sig, true
+	}
+	if sig, ok := stdlib.Signature(name); ok {
+		return sig, true
+	}
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Func {
+		return "", false
+	}
+	if _, ok := rv.Interface().(vm.Func); ok {
+		return name + "(args...)", true
+	}
+	return name + strings.TrimPrefix(rv.Type().String(), "func"), true
+}
+
+// builtinPrint 打印到 Stdout, 每个参数和 str 的结果相同
+func (in *Interpreter) builtinPrint(args ...reflect.Value) (reflect.Value, error) {
+	var buf strings.Builder
+	var prevString bool
+	for i, arg := range args {
+		if arg.Kind() == reflect.Interface {
+			arg = arg.Elem()
+		}
+		// 和 fmt.Print 一样, 相邻的两个参数都不是字符串时中间加空格
+		isString := arg.Kind() == reflect.String
+		if i > 0 && !isString && !prevString {
+			buf.WriteString(" ")
+		}
+		prevString = isString
+		buf.WriteString(vm.ToString(arg))
+	}
+	fmt.Fprint(in.Stdout, buf.String())
+	return vm.NilValue, nil
+}
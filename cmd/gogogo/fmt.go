@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/lth-go/gogogo"
+	"github.com/lth-go/gogogo/format"
+)
+
+//////////////////////////////
+// gogogo fmt
+//////////////////////////////
+
+func fmtUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "usage: gogogo fmt [-w] [-d] [-l] files...\n")
+		fs.PrintDefaults()
+	}
+}
+
+// runFmt 格式化文件, 默认输出到标准输出
+// -d 和 -l 是检查模式, 有文件没有格式化时返回 1
+func runFmt(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to source file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	fs.Usage = fmtUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	in := gogogo.New()
+	status := 0
+	for _, filename := range fs.Args() {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		res, err := format.Source(filename, src)
+		if err != nil {
+			in.PrintError(err)
+			status = 2
+			continue
+		}
+
+		changed := !bytes.Equal(src, res)
+		if changed && (*diff || *list) && status == 0 {
+			status = 1
+		}
+		if *list && changed {
+			fmt.Println(filename)
+		}
+		if *diff && changed {
+			fmt.Print(unifiedDiff(filename, string(src), string(res)))
+		}
+		if *write && changed {
+			if err := ioutil.WriteFile(filename, res, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+			}
+		}
+		if !*write && !*diff && !*list {
+			os.Stdout.Write(res)
+		}
+	}
+	return status
+}
+
+// unifiedDiff 按行比较, 输出 diff -u 的格式
+func unifiedDiff(filename, a, b string) string {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// lcs[i][j] 是 x[i:] 和 y[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// 每一行的操作: ' ' 相同, '-' 删除, '+' 增加
+	type edit struct {
+		op   byte
+		text string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i]})
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	const context = 3
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s.orig\n+++ %s\n", filename, filename)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		// 一段修改和前后 context 行, 修改之间相隔不超过 2*context 行时合并
+		lo := start - context
+		if lo < 0 {
+			lo = 0
+		}
+		hi := start
+		for k := start; k < len(edits) && k <= hi+2*context; k++ {
+			if edits[k].op != ' ' {
+				hi = k
+			}
+		}
+		end := hi + context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		// 行号从 1 开始
+		aLine, bLine := 1, 1
+		for _, e := range edits[:lo] {
+			if e.op != '+' {
+				aLine++
+			}
+			if e.op != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, e := range edits[lo:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, e := range edits[lo:end] {
+			text := e.text
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n\\ No newline at end of file\n"
+			}
+			buf.WriteByte(e.op)
+			buf.WriteString(text)
+		}
+		start = end
+	}
+	return buf.String()
+}
+
+// splitLines 按行切分, 每行保留换行符
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lth-go/gogogo"
+	"github.com/lth-go/gogogo/debugger"
+	"github.com/lth-go/gogogo/lsp"
+	"github.com/lth-go/gogogo/parse"
+)
+
+// 执行引擎: tree 遍历语法树, bytecode 编译成字节码执行
+var engine = flag.String("engine", "tree", "execution engine: tree or bytecode")
+
+// import 的搜索路径, 多个目录用系统的路径分隔符隔开
+var importPath = flag.String("path", os.Getenv("GOGOGOPATH"), "import search path, separated by '"+string(filepath.ListSeparator)+"'")
+
+var debug = flag.Bool("debug", false, "print tokens and syntax tree")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: gogogo [flags] [file | repl]\n")
+	fmt.Fprintf(os.Stderr, "       gogogo fmt [-w] [-d] [-l] files...\n")
+	fmt.Fprintf(os.Stderr, "       gogogo lsp\n")
+	fmt.Fprintf(os.Stderr, "       gogogo dap\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	parse.Debug = *debug
+
+	if flag.NArg() > 0 && flag.Arg(0) == "fmt" {
+		os.Exit(runFmt(flag.Args()[1:]))
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "lsp" {
+		in := gogogo.New()
+		in.ImportStdlib()
+		if err := lsp.NewServer(in).Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "dap" {
+		if err := debugger.NewServer().Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	in := gogogo.New()
+	in.ImportStdlib()
+	if *importPath != "" {
+		in.SetImportPath(filepath.SplitList(*importPath)...)
+	}
+	switch *engine {
+	case "tree":
+	case "bytecode":
+		in.Bytecode = true
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine '%s'\n", *engine)
+		os.Exit(2)
+	}
+
+	// 交互模式
+	if flag.NArg() == 0 || flag.NArg() == 1 && flag.Arg(0) == "repl" {
+		repl(in)
+		return
+	}
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if _, err := in.EvalFile(flag.Arg(0)); err != nil {
+		in.PrintError(err)
+		os.Exit(1)
+	}
+}
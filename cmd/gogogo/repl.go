@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lth-go/gogogo"
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// 交互模式
+//////////////////////////////
+
+const replHelp = `:help       显示帮助
+:history    显示历史输入
+!n          重新执行第 n 条历史输入
+:quit       退出
+`
+
+// history 历史输入, 保存在 ~/.gogogo_history
+type history struct {
+	entries []string
+	file    string
+}
+
+func loadHistory() *history {
+	h := &history{}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return h
+	}
+	h.file = filepath.Join(home, ".gogogo_history")
+
+	f, err := os.Open(h.file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// 多行输入保存时把换行转义
+		if entry, err := strconv.Unquote(s.Text()); err == nil {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	return h
+}
+
+func (h *history) add(entry string) {
+	h.entries = append(h.entries, entry)
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strconv.Quote(entry))
+}
+
+// repl 读取一条输入, 执行并打印结果, 所有输入共用一个解释器
+func repl(in *gogogo.Interpreter) {
+	h := loadHistory()
+	input := bufio.NewScanner(os.Stdin)
+	input.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for {
+		if len(lines) == 0 {
+			fmt.Print(">>> ")
+		} else {
+			fmt.Print("... ")
+		}
+		if !input.Scan() {
+			fmt.Println()
+			return
+		}
+		line := input.Text()
+
+		if len(lines) == 0 {
+			cmd := strings.TrimSpace(line)
+			switch {
+			case cmd == "":
+				continue
+			case cmd == ":quit" || cmd == ":exit":
+				return
+			case cmd == ":help":
+				fmt.Print(replHelp)
+				continue
+			case cmd == ":history":
+				for i, entry := range h.entries {
+					fmt.Printf("%5d  %s\n", i+1, strings.Replace(entry, "\n", "\n       ", -1))
+				}
+				continue
+			case strings.HasPrefix(cmd, "!"):
+				n, err := strconv.Atoi(cmd[1:])
+				if err != nil || n < 1 || n > len(h.entries) {
+					fmt.Fprintf(os.Stderr, "没有第%s条历史输入\n", cmd[1:])
+					continue
+				}
+				line = h.entries[n-1]
+				fmt.Println(line)
+			}
+		}
+
+		// 括号没有闭合时继续输入
+		lines = append(lines, line)
+		src := strings.Join(lines, "\n")
+		if parse.Incomplete(src) {
+			continue
+		}
+		lines = nil
+
+		h.add(src)
+		evalInput(src, in)
+	}
+}
+
+// evalInput 执行一条输入, 表达式语句打印它的值, 出错时不退出
+func evalInput(src string, in *gogogo.Interpreter) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(os.Stderr, "panic:", r)
+		}
+	}()
+
+	t, err := parse.Parse(src)
+	if err != nil {
+		// 最后一条语句可以省略分号
+		if t2, err2 := parse.Parse(src + ";"); err2 == nil {
+			t, err, src = t2, nil, src+";"
+		}
+	}
+	if err != nil {
+		in.PrintError(err)
+		return
+	}
+
+	v, err := in.Eval(src)
+	if err != nil {
+		in.PrintError(err)
+		return
+	}
+
+	if len(t.Root) == 0 || v == nil {
+		return
+	}
+	stmt, ok := t.Root[len(t.Root)-1].(*parse.ExprStmt)
+	if !ok {
+		return
+	}
+	if _, ok := stmt.Expr.(*parse.FuncExpr); ok {
+		return
+	}
+	if s, ok := v.(string); ok {
+		fmt.Println(strconv.Quote(s))
+		return
+	}
+	fmt.Println(vm.ToString(reflect.ValueOf(v)))
+}
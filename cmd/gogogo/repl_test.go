@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/lth-go/gogogo"
+)
+
+// TestREPL 表达式语句打印它的值, 没有闭合的输入继续读下一行
+func TestREPL(t *testing.T) {
+	// 历史输入不写到用户目录
+	t.Setenv("HOME", t.TempDir())
+	input := `x = 1
+x + 1
+"a" + "b"
+func double(a) {
+    return a * 2;
+}
+double(3)
+y
+:quit
+`
+	want := `>>> >>> 2
+>>> "ab"
+>>> ... ... >>> 6
+>>> <eval>:第1行:第1列: Undefined symbol 'y'
+>>> `
+	for _, bytecode := range []bool{false, true} {
+		got := capture(t, input, func() {
+			in := gogogo.New()
+			in.Bytecode = bytecode
+			repl(in)
+		})
+		if got != want {
+			t.Errorf("bytecode=%v:\n%s\nwant:\n%s", bytecode, got, want)
+		}
+	}
+}
+
+// capture 用 input 作为标准输入执行 f, 返回标准输出和错误输出
+func capture(t *testing.T, input string, f func()) string {
+	ir, iw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		iw.Write([]byte(input))
+		iw.Close()
+	}()
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+
+	stdin, stdout, stderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = ir, w, w
+	f()
+	os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr
+	w.Close()
+	ir.Close()
+	return <-out
+}
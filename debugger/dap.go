@@ -0,0 +1,545 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/lth-go/gogogo"
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// DAP 消息
+//////////////////////////////
+
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"` // request, response, event
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"` // 只有 response 有
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+type launchArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+}
+
+type stackFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Source source `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+//////////////////////////////
+// 服务
+//////////////////////////////
+
+// Server DAP 服务, 一次调试一个脚本文件
+type Server struct {
+	w   io.Writer
+	mu  sync.Mutex // 保护 w 和 seq
+	seq int
+
+	program string
+	in      *gogogo.Interpreter
+	d       *Debugger
+	started bool
+	done    chan struct{} // 脚本执行结束时关闭
+
+	handles []interface{} // variablesReference 减 1 是下标, 元素是 *vm.Env 或 reflect.Value
+}
+
+// NewServer 新的 DAP 服务
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Serve 处理请求直到收到 disconnect 或者输入结束
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	tr := textproto.NewReader(bufio.NewReader(r))
+	for {
+		msg, err := readMessage(tr)
+		if err == io.EOF {
+			s.terminate()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg == nil || msg.Type != "request" {
+			continue
+		}
+		if !s.handle(msg) {
+			return nil
+		}
+	}
+}
+
+// maxMessageSize 消息的最大长度, 和 lsp 相同
+const maxMessageSize = 64 << 20
+
+// readMessage 读取一条消息, 格式是 Content-Length 头加 JSON
+func readMessage(tr *textproto.Reader) (*message, error) {
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if n < 0 || n > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length: %d, at most %d bytes", n, maxMessageSize)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(tr.R, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (s *Server) write(msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) respond(req *message, body interface{}, err error) {
+	success := err == nil
+	msg := &message{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: &success, Body: body}
+	if err != nil {
+		msg.Message = err.Error()
+	}
+	s.write(msg)
+}
+
+func (s *Server) event(event string, body interface{}) {
+	s.write(&message{Type: "event", Event: event, Body: body})
+}
+
+// handle 处理一个请求, 返回 false 时结束服务
+func (s *Server) handle(req *message) bool {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{"supportsConfigurationDoneRequest": true, "supportsTerminateRequest": true}, nil)
+		s.event("initialized", nil)
+		return true
+	case "launch":
+		var args launchArguments
+		if err = json.Unmarshal(req.Arguments, &args); err == nil {
+			err = s.launch(args)
+		}
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err = json.Unmarshal(req.Arguments, &args); err == nil {
+			body, err = s.setBreakpoints(args)
+		}
+	case "setExceptionBreakpoints":
+	case "configurationDone":
+		if s.d == nil || s.started {
+			err = fmt.Errorf("not launched")
+			break
+		}
+		s.respond(req, nil, nil)
+		s.started = true
+		go s.run()
+		return true
+	case "threads":
+		body = map[string]interface{}{"threads": []map[string]interface{}{{"id": 1, "name": "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err = json.Unmarshal(req.Arguments, &args); err == nil {
+			body, err = s.scopes(args.FrameID)
+		}
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err = json.Unmarshal(req.Arguments, &args); err == nil {
+			body, err = s.variables(args.VariablesReference)
+		}
+	case "continue", "next", "stepIn", "stepOut":
+		if s.d == nil || s.d.Stopped() == nil {
+			err = fmt.Errorf("not stopped")
+			break
+		}
+		// 继续执行后之前的变量引用都失效
+		s.handles = nil
+		if req.Command == "continue" {
+			body = map[string]bool{"allThreadsContinued": true}
+		}
+		s.respond(req, body, nil)
+		switch req.Command {
+		case "continue":
+			s.d.Continue()
+		case "next":
+			s.d.StepOver()
+		case "stepIn":
+			s.d.StepIn()
+		case "stepOut":
+			s.d.StepOut()
+		}
+		return true
+	case "pause":
+		if s.d != nil {
+			s.d.Pause()
+		}
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.terminate()
+		s.respond(req, nil, nil)
+		return false
+	default:
+		err = fmt.Errorf("unsupported request: %s", req.Command)
+	}
+
+	s.respond(req, body, err)
+	return true
+}
+
+// launch 读取脚本, 在 configurationDone 之后才开始执行
+func (s *Server) launch(args launchArguments) error {
+	if s.d != nil {
+		return fmt.Errorf("already launched")
+	}
+	if args.Program == "" {
+		return fmt.Errorf("missing program")
+	}
+	if _, err := ioutil.ReadFile(args.Program); err != nil {
+		return err
+	}
+
+	s.program = args.Program
+	s.d = New(args.Program, args.StopOnEntry)
+	s.d.OnStop = func(stop *Stop) {
+		s.event("stopped", map[string]interface{}{"reason": stop.Reason, "threadId": 1, "allThreadsStopped": true})
+	}
+	s.in = gogogo.New()
+	s.in.ImportStdlib()
+	s.in.Stdout = &output{s: s, category: "stdout"}
+	s.in.Stderr = &output{s: s, category: "stderr"}
+	s.in.SetHook(s.d.Hook)
+	s.done = make(chan struct{})
+	return nil
+}
+
+// run 执行脚本, 结束时发送 exited 和 terminated
+func (s *Server) run() {
+	defer close(s.done)
+
+	code := 0
+	if _, err := s.in.EvalFile(s.program); err != nil {
+		if _, ok := err.(*vm.InterruptError); !ok {
+			s.in.PrintError(err)
+		}
+		code = 1
+	}
+	s.event("exited", map[string]int{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+// terminate 停止正在执行的脚本并等待结束
+func (s *Server) terminate() {
+	if s.d == nil {
+		return
+	}
+	s.d.Terminate()
+	if s.started {
+		<-s.done
+	}
+}
+
+// setBreakpoints 只有某条语句开始的行才能设置断点
+func (s *Server) setBreakpoints(args setBreakpointsArguments) (interface{}, error) {
+	lines := make(map[int]bool)
+	if src, err := ioutil.ReadFile(args.Source.Path); err == nil {
+		if t, err := parse.ParseFile(args.Source.Path, string(src)); err == nil {
+			stmtLines(t.Root, lines)
+		}
+	}
+
+	bps := []breakpoint{}
+	var verified []int
+	for _, bp := range args.Breakpoints {
+		bps = append(bps, breakpoint{Verified: lines[bp.Line], Line: bp.Line})
+		if lines[bp.Line] {
+			verified = append(verified, bp.Line)
+		}
+	}
+	if s.d == nil {
+		return nil, fmt.Errorf("not launched")
+	}
+	s.d.SetBreakpoints(args.Source.Path, verified)
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+func (s *Server) stackTrace() (interface{}, error) {
+	stop, err := s.stop()
+	if err != nil {
+		return nil, err
+	}
+	frames := []stackFrame{}
+	for i, f := range stop.Stack {
+		frames = append(frames, stackFrame{
+			ID:     i + 1,
+			Name:   f.Func,
+			Source: source{Path: f.File},
+			Line:   f.Pos.Line,
+			Column: f.Pos.Column,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// scopes 从当前环境到全局环境, 每层环境是一个作用域
+func (s *Server) scopes(frameID int) (interface{}, error) {
+	stop, err := s.stop()
+	if err != nil {
+		return nil, err
+	}
+	if frameID < 1 || frameID > len(stop.Stack) {
+		return nil, fmt.Errorf("invalid frame %d", frameID)
+	}
+	scopes := []scope{}
+	for env := stop.Stack[frameID-1].Env; env != nil; env = env.Parent() {
+		name := "Locals"
+		if env.Parent() == nil {
+			name = "Globals"
+		} else if len(scopes) > 0 {
+			name = "Outer"
+		}
+		scopes = append(scopes, scope{Name: name, VariablesReference: s.newHandle(env)})
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// variables 环境中的变量, 数组和字典的元素
+func (s *Server) variables(ref int) (interface{}, error) {
+	if _, err := s.stop(); err != nil {
+		return nil, err
+	}
+	if ref < 1 || ref > len(s.handles) {
+		return nil, fmt.Errorf("invalid variables reference %d", ref)
+	}
+	vars := []variable{}
+	switch h := s.handles[ref-1].(type) {
+	case *vm.Env:
+		values := h.Values()
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			vars = append(vars, s.variable(name, values[name]))
+		}
+	case reflect.Value:
+		switch h.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < h.Len(); i++ {
+				vars = append(vars, s.variable("["+strconv.Itoa(i)+"]", h.Index(i)))
+			}
+		case reflect.Map:
+			for _, k := range vm.SortedKeys(h) {
+				vars = append(vars, s.variable(quote(k), h.MapIndex(k)))
+			}
+		}
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *Server) variable(name string, v reflect.Value) variable {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	vr := variable{Name: name, Value: quote(v)}
+	if v.IsValid() && v != vm.NilValue {
+		vr.Type = v.Type().String()
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			if v.Len() > 0 {
+				vr.VariablesReference = s.newHandle(v)
+			}
+		}
+	}
+	return vr
+}
+
+// newHandle 分配 variablesReference
+func (s *Server) newHandle(h interface{}) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+func (s *Server) stop() (*Stop, error) {
+	if s.d == nil {
+		return nil, fmt.Errorf("not launched")
+	}
+	stop := s.d.Stopped()
+	if stop == nil {
+		return nil, fmt.Errorf("not stopped")
+	}
+	return stop, nil
+}
+
+// quote 字符串加引号, 其他值和 print 的输出相同
+func quote(v reflect.Value) string {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.String {
+		return strconv.Quote(v.String())
+	}
+	return vm.ToString(v)
+}
+
+// output 把脚本的输出作为 output 事件发送
+type output struct {
+	s        *Server
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]string{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+//////////////////////////////
+// 语句所在的行
+//////////////////////////////
+
+func stmtLines(stmts []parse.Stmt, lines map[int]bool) {
+	for _, stmt := range stmts {
+		lines[stmt.Position().Line] = true
+		switch s := stmt.(type) {
+		case *parse.ExprStmt:
+			exprLines(s.Expr, lines)
+		case *parse.LetsStmt:
+			for _, e := range s.Rhss {
+				exprLines(e, lines)
+			}
+		case *parse.IfStmt:
+			exprLines(s.Condition, lines)
+			stmtLines(s.Do, lines)
+			stmtLines(s.Elif, lines)
+			stmtLines(s.Else, lines)
+		case *parse.ForStmt:
+			stmtLines(s.Do, lines)
+		case *parse.ForInStmt:
+			exprLines(s.Expr, lines)
+			stmtLines(s.Do, lines)
+		case *parse.ReturnStmt:
+			exprLines(s.Expr, lines)
+		case *parse.TryStmt:
+			stmtLines(s.Try, lines)
+			stmtLines(s.Catch, lines)
+			stmtLines(s.Finally, lines)
+		case *parse.ThrowStmt:
+			exprLines(s.Expr, lines)
+		case *parse.SwitchStmt:
+			exprLines(s.Expr, lines)
+			for _, c := range s.Cases {
+				for _, e := range c.Exprs {
+					exprLines(e, lines)
+				}
+				stmtLines(c.Do, lines)
+			}
+		}
+	}
+}
+
+// exprLines 表达式中函数体的语句
+func exprLines(expr parse.Expr, lines map[int]bool) {
+	switch e := expr.(type) {
+	case *parse.FuncExpr:
+		stmtLines(e.Stmts, lines)
+	case *parse.CallExpr:
+		exprLines(e.Func, lines)
+		for _, sub := range e.SubExprs {
+			exprLines(sub, lines)
+		}
+	case *parse.LetsExpr:
+		for _, rhs := range e.Rhss {
+			exprLines(rhs, lines)
+		}
+	case *parse.ParenExpr:
+		exprLines(e.SubExpr, lines)
+	case *parse.ArrayExpr:
+		for _, sub := range e.Exprs {
+			exprLines(sub, lines)
+		}
+	case *parse.MapExpr:
+		for _, sub := range e.Values {
+			exprLines(sub, lines)
+		}
+	case *parse.InterpExpr:
+		for _, sub := range e.Exprs {
+			exprLines(sub, lines)
+		}
+	}
+}
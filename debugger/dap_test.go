@@ -0,0 +1,47 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestServer 只有 response 有 success 字段, 失败的请求带错误信息
+func TestServer(t *testing.T) {
+	var in, out bytes.Buffer
+	for _, m := range []string{
+		`{"seq":1,"type":"request","command":"initialize","arguments":{}}`,
+		`{"seq":2,"type":"request","command":"stackTrace"}`,
+		`{"seq":3,"type":"request","command":"disconnect"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := NewServer().Serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"seq":1,"type":"response","command":"initialize","request_seq":1,"success":true,"body":{"supportsConfigurationDoneRequest":true,"supportsTerminateRequest":true}}`,
+		`{"seq":2,"type":"event","event":"initialized"}`,
+		`{"seq":3,"type":"response","command":"stackTrace","request_seq":2,"success":false,"message":"not launched"}`,
+		`{"seq":4,"type":"response","command":"disconnect","request_seq":3,"success":true}`,
+	}
+	var got []string
+	for _, s := range strings.Split(out.String(), "Content-Length: ")[1:] {
+		got = append(got, s[strings.Index(s, "\r\n\r\n")+4:])
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestMessageSize 长度为负数或者过长的消息直接报错, 不分配内存
+func TestMessageSize(t *testing.T) {
+	for _, n := range []string{"-1", "67108865"} {
+		in := strings.NewReader("Content-Length: " + n + "\r\n\r\n{}")
+		if err := NewServer().Serve(in, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "invalid Content-Length") {
+			t.Errorf("Content-Length %s: got %v, want invalid Content-Length", n, err)
+		}
+	}
+}
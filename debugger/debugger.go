@@ -0,0 +1,223 @@
+// Package debugger 单步调试器和 Debug Adapter Protocol 服务
+package debugger
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+// 执行模式
+type mode int
+
+const (
+	modeContinue mode = iota
+	modeStepIn
+	modeStepOver
+	modeStepOut
+	modePause
+)
+
+// Stop 调试器停下时的原因和调用栈
+type Stop struct {
+	Reason string // entry, breakpoint, step, pause
+	Stack  []vm.StackFrame
+}
+
+// Debugger 调试器, Hook 在执行脚本的 goroutine 中调用, 停下时阻塞直到被继续
+type Debugger struct {
+	// OnStop 停下时调用, 在执行脚本的 goroutine 中
+	OnStop func(*Stop)
+
+	program string // 顶层代码所在的文件
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int]bool
+	mode        mode
+	depth       int // 开始单步时的调用深度
+	stopped     *Stop
+	terminated  bool
+	resume      chan struct{}
+
+	// 上一条语句, 同一行的多条语句只停一次
+	lastPos   parse.Position
+	lastDepth int
+}
+
+// New 新的调试器, program 是顶层代码所在的文件, stopOnEntry 时在第一条语句前停下
+func New(program string, stopOnEntry bool) *Debugger {
+	d := &Debugger{
+		program:     clean(program),
+		breakpoints: make(map[string]map[int]bool),
+		resume:      make(chan struct{}, 1),
+	}
+	if stopOnEntry {
+		d.mode = modeStepIn
+	}
+	return d
+}
+
+func clean(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return filepath.Clean(file)
+}
+
+// SetBreakpoints 替换文件中的所有断点
+func (d *Debugger) SetBreakpoints(file string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m := make(map[int]bool, len(lines))
+	for _, line := range lines {
+		m[line] = true
+	}
+	d.breakpoints[clean(file)] = m
+}
+
+// Hook 设置为解释器的调试钩子
+func (d *Debugger) Hook(stmt parse.Stmt, env *vm.Env) error {
+	pos := stmt.Position()
+	depth := env.Depth()
+
+	d.mu.Lock()
+	if d.terminated {
+		d.mu.Unlock()
+		return &vm.InterruptError{Reason: "terminated by debugger", Pos: pos}
+	}
+
+	// 换了一行, 进出函数, 或者循环回到前面的语句
+	newLine := depth != d.lastDepth || pos.Line != d.lastPos.Line || !before(d.lastPos, pos)
+	entry := d.lastDepth == 0
+	d.lastPos, d.lastDepth = pos, depth
+
+	reason := ""
+	switch d.mode {
+	case modeStepIn:
+		if newLine {
+			reason = "step"
+		}
+	case modeStepOver:
+		if newLine && depth <= d.depth {
+			reason = "step"
+		}
+	case modeStepOut:
+		if depth < d.depth {
+			reason = "step"
+		}
+	case modePause:
+		reason = "pause"
+	}
+	if reason == "step" && entry {
+		reason = "entry"
+	}
+	if reason == "" && newLine && d.hasBreakpoint(env, pos.Line) {
+		reason = "breakpoint"
+	}
+	if reason == "" {
+		d.mu.Unlock()
+		return nil
+	}
+
+	stop := &Stop{Reason: reason, Stack: env.Stack()}
+	for i := range stop.Stack {
+		if stop.Stack[i].File == "" {
+			stop.Stack[i].File = d.program
+		}
+	}
+	d.stopped = stop
+	d.mode = modePause
+	d.depth = depth
+	d.mu.Unlock()
+
+	if d.OnStop != nil {
+		d.OnStop(stop)
+	}
+	<-d.resume
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.terminated {
+		return &vm.InterruptError{Reason: "terminated by debugger", Pos: pos}
+	}
+	return nil
+}
+
+// hasBreakpoint 当前函数所在的文件在这一行有没有断点
+func (d *Debugger) hasBreakpoint(env *vm.Env, line int) bool {
+	found := false
+	for _, lines := range d.breakpoints {
+		if lines[line] {
+			found = true
+		}
+	}
+	// 先按行号过滤, 避免每条语句都复制调用栈
+	if !found {
+		return false
+	}
+	file := env.Stack()[0].File
+	if file == "" {
+		file = d.program
+	} else {
+		file = clean(file)
+	}
+	return d.breakpoints[file][line]
+}
+
+func before(a, b parse.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// Stopped 停下时的状态, 正在执行时返回 nil
+func (d *Debugger) Stopped() *Stop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stopped
+}
+
+// cont 以指定的模式继续执行
+func (d *Debugger) cont(m mode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped == nil {
+		return
+	}
+	d.stopped = nil
+	d.mode = m
+	d.resume <- struct{}{}
+}
+
+// Continue 继续执行到下一个断点
+func (d *Debugger) Continue() { d.cont(modeContinue) }
+
+// StepIn 执行到下一行, 包括进入调用的函数
+func (d *Debugger) StepIn() { d.cont(modeStepIn) }
+
+// StepOver 执行到当前函数的下一行
+func (d *Debugger) StepOver() { d.cont(modeStepOver) }
+
+// StepOut 执行到返回调用者
+func (d *Debugger) StepOut() { d.cont(modeStepOut) }
+
+// Pause 在下一条语句前停下
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped == nil {
+		d.mode = modePause
+	}
+}
+
+// Terminate 停止执行, 脚本返回 *vm.InterruptError
+func (d *Debugger) Terminate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.terminated = true
+	if d.stopped != nil {
+		d.stopped = nil
+		d.resume <- struct{}{}
+	}
+}
@@ -0,0 +1,68 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/lth-go/gogogo"
+)
+
+// TestDebugger 在入口, 断点和单步时停下, 停下的位置是调用栈最里层的语句
+func TestDebugger(t *testing.T) {
+	src := `func f(x) {
+    y = x * 2;
+    return y;
+}
+a = 1;
+b = f(a);
+print(b);
+`
+	d := New("main.gg", true)
+	d.SetBreakpoints("main.gg", []int{3})
+	stops := make(chan *Stop)
+	d.OnStop = func(s *Stop) { stops <- s }
+
+	var out bytes.Buffer
+	in := gogogo.New()
+	in.Stdout = &out
+	in.SetHook(d.Hook)
+	done := make(chan error)
+	go func() {
+		_, err := in.Eval(src)
+		done <- err
+	}()
+
+	steps := []struct {
+		cont func()
+		want string
+	}{
+		{nil, "entry 1"},
+		{d.StepOver, "step 5"},
+		{d.StepOver, "step 6"},
+		{d.StepIn, "step 2"},
+		{d.Continue, "breakpoint 3"},
+		{d.StepOut, "step 7"},
+	}
+	for _, step := range steps {
+		if step.cont != nil {
+			step.cont()
+		}
+		select {
+		case s := <-stops:
+			if got := fmt.Sprintf("%s %d", s.Reason, s.Stack[0].Pos.Line); got != step.want {
+				t.Fatalf("stopped at %s, want %s", got, step.want)
+			}
+		case err := <-done:
+			t.Fatalf("finished with %v, want %s", err, step.want)
+		}
+	}
+
+	d.Continue()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "2" {
+		t.Errorf("got output %q, want 2", out.String())
+	}
+}
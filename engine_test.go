@@ -0,0 +1,57 @@
+package gogogo
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用遍历语法树的输出更新 testdata 中的 .out 文件")
+
+// TestScripts 两个引擎执行 testdata 中的脚本, 输出和错误都要和 .out 文件相同
+func TestScripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.gg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		golden := strings.TrimSuffix(file, ".gg") + ".out"
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(runScript(file, false)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bytecode := range []bool{false, true} {
+			if got := runScript(file, bytecode); got != string(want) {
+				t.Errorf("%s bytecode=%v:\n%s\nwant:\n%s", file, bytecode, got, want)
+			}
+		}
+	}
+}
+
+// runScript 导入标准库后执行脚本, 返回 print 的输出和错误
+func runScript(file string, bytecode bool) string {
+	var out bytes.Buffer
+	in := New()
+	in.ImportStdlib()
+	in.Bytecode = bytecode
+	in.Stdout = &out
+	in.Stderr = &out
+	if _, err := in.EvalFile(file); err != nil {
+		in.PrintError(err)
+	}
+	// 模块的路径是绝对路径, 换回相对路径
+	dir := filepath.Dir(file)
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.Replace(out.String(), abs, dir, -1)
+}
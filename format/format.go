@@ -0,0 +1,397 @@
+// Package format 把语法树输出成统一格式的源码
+package format
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+// indent 每层缩进
+const indent = "    "
+
+// Source 格式化源码, 语法错误时返回 parse.ErrorList, filename 只用于错误信息
+func Source(filename string, src []byte) ([]byte, error) {
+	t, err := parse.ParseFile(filename, string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Fprint(&buf, t); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fprint 把语法树输出到 w, 注释放回原来的语句前后
+func Fprint(w io.Writer, t *parse.Tree) error {
+	p := &printer{comments: t.Comments, empty: true}
+	p.stmts(t.Root, parse.Position{Line: 1 << 30})
+	p.flush(parse.Position{Line: 1 << 30})
+	if p.buf.Len() > 0 {
+		p.buf.WriteString("\n")
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+//////////////////////////////
+// printer
+//////////////////////////////
+
+type printer struct {
+	buf      bytes.Buffer
+	depth    int
+	comments []*parse.Comment // 还没有输出的注释
+	line     int              // 最后输出的内容在源码中的行号
+	empty    bool             // 当前语句块还没有输出内容
+	end      parse.Position   // 正在输出的语句的结束位置
+}
+
+func before(a, b parse.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// newline 换行并缩进, 源码中隔了空行时保留一个空行
+func (p *printer) newline(line int) {
+	if p.empty {
+		p.empty = false
+	} else {
+		p.buf.WriteString("\n")
+		if line > p.line+1 {
+			p.buf.WriteString("\n")
+		}
+	}
+	p.buf.WriteString(strings.Repeat(indent, p.depth))
+}
+
+// flush 输出位置在 pos 之前的注释, 每条注释独占一行
+func (p *printer) flush(pos parse.Position) {
+	for len(p.comments) > 0 && before(p.comments[0].Position(), pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.newline(c.Position().Line)
+		p.buf.WriteString(c.Text)
+		p.line = c.Position().Line + strings.Count(c.Text, "\n")
+	}
+}
+
+// trailing 输出和语句结尾在同一行的注释
+func (p *printer) trailing(end parse.Position) {
+	for len(p.comments) > 0 && p.comments[0].Position().Line == end.Line && before(end, p.comments[0].Position()) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.buf.WriteString(" ")
+		p.buf.WriteString(c.Text)
+		p.line = c.Position().Line + strings.Count(c.Text, "\n")
+	}
+}
+
+// stmts 输出语句列表, end 是语句块结束的位置, 之前的注释都在块内
+func (p *printer) stmts(stmts []parse.Stmt, end parse.Position) {
+	for _, stmt := range stmts {
+		p.flush(stmt.Position())
+		p.newline(stmt.Position().Line)
+		p.end = stmt.End()
+		p.stmt(stmt)
+		p.line = stmt.End().Line
+		p.trailing(stmt.End())
+	}
+	p.flush(end)
+}
+
+// block 输出 { 语句 }, 没有语句和注释时输出 {}
+func (p *printer) block(stmts []parse.Stmt, end parse.Position) {
+	if len(stmts) == 0 && (len(p.comments) == 0 || !before(p.comments[0].Position(), end)) {
+		p.buf.WriteString("{}")
+		return
+	}
+	p.buf.WriteString("{")
+	p.depth++
+	p.empty = false
+	p.line = 1 << 30 // 块的第一行前面不留空行
+	saved := p.end
+	p.stmts(stmts, end)
+	p.end = saved
+	p.depth--
+	p.buf.WriteString("\n" + strings.Repeat(indent, p.depth) + "}")
+}
+
+func (p *printer) stmt(stmt parse.Stmt) {
+	switch s := stmt.(type) {
+	case *parse.ExprStmt:
+		p.expr(s.Expr)
+		// 函数定义后面没有分号
+		if _, ok := s.Expr.(*parse.FuncExpr); !ok {
+			p.buf.WriteString(";")
+		}
+	case *parse.LetsStmt:
+		p.exprList(s.Lhss)
+		p.buf.WriteString(" " + s.Operator + " ")
+		p.exprList(s.Rhss)
+		p.buf.WriteString(";")
+	case *parse.IfStmt:
+		p.ifStmt(s)
+	case *parse.ForStmt:
+		p.buf.WriteString("for ")
+		if s.Initial == nil && s.After == nil {
+			// for {} 和 for cond {}
+			if s.Condition != nil {
+				p.expr(s.Condition)
+				p.buf.WriteString(" ")
+			}
+		} else {
+			p.optExpr(s.Initial)
+			p.buf.WriteString("; ")
+			p.optExpr(s.Condition)
+			p.buf.WriteString("; ")
+			if s.After != nil {
+				p.expr(s.After)
+				p.buf.WriteString(" ")
+			}
+		}
+		p.block(s.Do, s.End())
+	case *parse.ForInStmt:
+		p.buf.WriteString("for " + strings.Join(s.Vars, ", ") + " in ")
+		p.expr(s.Expr)
+		p.buf.WriteString(" ")
+		p.block(s.Do, s.End())
+	case *parse.TryStmt:
+		p.tryStmt(s)
+	case *parse.SwitchStmt:
+		p.switchStmt(s)
+	case *parse.FallthroughStmt:
+		p.buf.WriteString("fallthrough;")
+	case *parse.ThrowStmt:
+		p.buf.WriteString("throw ")
+		p.expr(s.Expr)
+		p.buf.WriteString(";")
+	case *parse.ImportStmt:
+		p.buf.WriteString(`import "` + s.Path + `";`)
+	case *parse.BreakStmt:
+		p.buf.WriteString("break;")
+	case *parse.ContinueStmt:
+		p.buf.WriteString("continue;")
+	case *parse.ReturnStmt:
+		p.buf.WriteString("return")
+		if s.Expr != nil {
+			p.buf.WriteString(" ")
+			p.expr(s.Expr)
+		}
+		p.buf.WriteString(";")
+	}
+}
+
+// ifStmt if 和 elif 的语句块到下一个 elif 或 else 为止
+func (p *printer) ifStmt(s *parse.IfStmt) {
+	// else 没有位置, 用 else 块的第一条语句代替
+	elseEnd := s.End()
+	if len(s.Else) > 0 {
+		elseEnd = s.Else[0].Position()
+	}
+	blockEnd := func(i int) parse.Position {
+		if i < len(s.Elif) {
+			return s.Elif[i].Position()
+		}
+		return elseEnd
+	}
+
+	p.buf.WriteString("if ")
+	p.expr(s.Condition)
+	p.buf.WriteString(" ")
+	p.block(s.Do, blockEnd(0))
+	for i, stmt := range s.Elif {
+		elif := stmt.(*parse.IfStmt)
+		p.buf.WriteString(" elif ")
+		p.expr(elif.Condition)
+		p.buf.WriteString(" ")
+		p.block(elif.Do, blockEnd(i+1))
+	}
+	if s.Else != nil {
+		p.buf.WriteString(" else ")
+		p.block(s.Else, s.End())
+	}
+}
+
+// tryStmt catch, finally 没有位置, 和 else 一样用后面语句块的第一条语句代替
+func (p *printer) tryStmt(s *parse.TryStmt) {
+	finallyEnd := s.End()
+	if len(s.Finally) > 0 {
+		finallyEnd = s.Finally[0].Position()
+	}
+	catchEnd := finallyEnd
+	if len(s.Catch) > 0 {
+		catchEnd = s.Catch[0].Position()
+	}
+
+	p.buf.WriteString("try ")
+	if s.Catch != nil {
+		p.block(s.Try, catchEnd)
+		p.buf.WriteString(" catch ")
+		if s.Var != "" {
+			p.buf.WriteString(s.Var + " ")
+		}
+		p.block(s.Catch, finallyEnd)
+	} else {
+		p.block(s.Try, finallyEnd)
+	}
+	if s.Finally != nil {
+		p.buf.WriteString(" finally ")
+		p.block(s.Finally, s.End())
+	}
+}
+
+// switchStmt case 和 switch 对齐, case 之前的注释放在上一个 case 中
+func (p *printer) switchStmt(s *parse.SwitchStmt) {
+	p.buf.WriteString("switch ")
+	p.expr(s.Expr)
+	if len(s.Cases) == 0 {
+		p.buf.WriteString(" ")
+		p.block(nil, s.End())
+		return
+	}
+	p.buf.WriteString(" {")
+	p.empty = false
+	p.line = 1 << 30
+	saved := p.end
+	for i, c := range s.Cases {
+		end := s.End()
+		if i+1 < len(s.Cases) {
+			end = s.Cases[i+1].Position()
+		}
+		p.flush(c.Position())
+		p.newline(c.Position().Line)
+		if c.Exprs == nil {
+			p.buf.WriteString("default:")
+		} else {
+			p.buf.WriteString("case ")
+			p.exprList(c.Exprs)
+			p.buf.WriteString(":")
+		}
+		p.line = c.Position().Line
+		p.depth++
+		p.stmts(c.Do, end)
+		p.depth--
+	}
+	p.end = saved
+	p.buf.WriteString("\n" + strings.Repeat(indent, p.depth) + "}")
+}
+
+// quote 没有源码写法的字符串转义后的内容, 不包括引号
+func quote(s string) string {
+	q := strconv.Quote(s)
+	return strings.ReplaceAll(q[1:len(q)-1], "${", `\${`)
+}
+
+func (p *printer) optExpr(expr parse.Expr) {
+	if expr != nil {
+		p.expr(expr)
+	}
+}
+
+func (p *printer) exprList(exprs []parse.Expr) {
+	for i, expr := range exprs {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expr(expr)
+	}
+}
+
+func (p *printer) expr(expr parse.Expr) {
+	switch e := expr.(type) {
+	case *parse.NumberExpr:
+		p.buf.WriteString(e.Lit)
+	case *parse.StringExpr:
+		// 保留转义和反引号的写法
+		if e.Raw != "" {
+			p.buf.WriteString(e.Raw)
+		} else {
+			p.buf.WriteString(`"` + quote(e.Lit) + `"`)
+		}
+	case *parse.InterpExpr:
+		for i, lit := range e.Lits {
+			switch {
+			case i < len(e.Raws) && e.Raws[i] != "":
+				p.buf.WriteString(e.Raws[i])
+			case i == 0:
+				p.buf.WriteString(`"` + quote(lit) + "${")
+			case i == len(e.Exprs):
+				p.buf.WriteString("}" + quote(lit) + `"`)
+			default:
+				p.buf.WriteString("}" + quote(lit) + "${")
+			}
+			if i < len(e.Exprs) {
+				p.expr(e.Exprs[i])
+			}
+		}
+	case *parse.IdentExpr:
+		p.buf.WriteString(e.Lit)
+	case *parse.ConstExpr:
+		p.buf.WriteString(e.Value)
+	case *parse.UnaryExpr:
+		p.buf.WriteString(e.Operator)
+		p.expr(e.Expr)
+	case *parse.ParenExpr:
+		p.buf.WriteString("(")
+		p.expr(e.SubExpr)
+		p.buf.WriteString(")")
+	case *parse.BinOpExpr:
+		p.expr(e.Lhs)
+		p.buf.WriteString(" " + e.Operator + " ")
+		p.expr(e.Rhs)
+	case *parse.LetsExpr:
+		p.exprList(e.Lhss)
+		p.buf.WriteString(" = ")
+		p.exprList(e.Rhss)
+	case *parse.FuncExpr:
+		p.buf.WriteString("func")
+		if e.Name != "" {
+			p.buf.WriteString(" " + e.Name)
+		}
+		p.buf.WriteString("(" + strings.Join(e.Args, ", ") + ") ")
+		p.block(e.Stmts, p.end)
+	case *parse.CallExpr:
+		if e.Func != nil {
+			p.expr(e.Func)
+		} else {
+			p.buf.WriteString(e.Name)
+		}
+		p.buf.WriteString("(")
+		p.exprList(e.SubExprs)
+		p.buf.WriteString(")")
+	case *parse.ArrayExpr:
+		p.buf.WriteString("[")
+		p.exprList(e.Exprs)
+		p.buf.WriteString("]")
+	case *parse.MapExpr:
+		p.buf.WriteString("{")
+		for i := range e.Keys {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.expr(e.Keys[i])
+			p.buf.WriteString(": ")
+			p.expr(e.Values[i])
+		}
+		p.buf.WriteString("}")
+	case *parse.IndexExpr:
+		p.expr(e.Value)
+		p.buf.WriteString("[")
+		p.expr(e.Index)
+		p.buf.WriteString("]")
+	case *parse.MemberExpr:
+		p.expr(e.Expr)
+		p.buf.WriteString("." + e.Name)
+	case *parse.SliceExpr:
+		p.expr(e.Value)
+		p.buf.WriteString("[")
+		p.optExpr(e.Begin)
+		p.buf.WriteString(":")
+		p.optExpr(e.End)
+		p.buf.WriteString("]")
+	}
+}
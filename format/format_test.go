@@ -0,0 +1,61 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+// TestSource 格式化后和 want 相同, 再格式化一次不变
+func TestSource(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			"x=1+2*3;   a,b=b,a;\n",
+			"x = 1 + 2 * 3;\na, b = b, a;\n",
+		},
+		{
+			"func f(a,b){\nif a>b{return a;}elif a==b{\nreturn 0;}else{ return b; }\n}\n",
+			"func f(a, b) {\n    if a > b {\n        return a;\n    } elif a == b {\n        return 0;\n    } else {\n        return b;\n    }\n}\n",
+		},
+		{
+			"// 注释\nm = {\"a\":[1,2,3], 2:\"b\"};  /* 行尾 */\n",
+			"// 注释\nm = {\"a\": [1, 2, 3], 2: \"b\"}; /* 行尾 */\n",
+		},
+		{
+			"for i=0;i<3;i=i+1{ print(m[\"a\"][i], -x, !true); }\n",
+			"for i = 0; i < 3; i = i + 1 {\n    print(m[\"a\"][i], -x, !true);\n}\n",
+		},
+		{
+			// 保留需要的括号, 连续的空行只留一行
+			"x = (1 + 2) * 3 - (4 - 5);\ny = -(a + b);\n\n\nz = f(1)(2)[1:];\n",
+			"x = (1 + 2) * 3 - (4 - 5);\ny = -(a + b);\n\nz = f(1)(2)[1:];\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Source("", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", tt.src, got, tt.want)
+			continue
+		}
+		again, err := Source("", got)
+		if err != nil || string(again) != string(got) {
+			t.Errorf("%q: not idempotent:\n%s", tt.src, again)
+		}
+	}
+}
+
+// TestSourceError 有语法错误时返回所有错误
+func TestSourceError(t *testing.T) {
+	_, err := Source("a.gg", []byte("x = ;\ny = 1;\nz = (;\n"))
+	errs, ok := err.(parse.ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got %v, want 2 syntax errors", err)
+	}
+}
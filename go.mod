@@ -0,0 +1,3 @@
+module github.com/lth-go/gogogo
+
+go 1.21
@@ -0,0 +1,105 @@
+package gogogo
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type Base struct {
+	ID int64
+}
+
+func (b Base) Describe() string { return fmt.Sprintf("base %d", b.ID) }
+
+type Person struct {
+	Base
+	Name string
+	Tags []string
+	age  int
+}
+
+func (p *Person) Rename(name string) string {
+	old := p.Name
+	p.Name = name
+	return old
+}
+
+func (p Person) Greet(greeting string) string { return greeting + ", " + p.Name }
+
+// goValueTests 脚本和 Go 值的交互, 每个脚本在新的解释器中执行
+var goValueTests = []struct {
+	src  string
+	want string
+}{
+	{`print(p.Name, " ", p.ID, " ", p.Tags[1]);`, "bob 7 y"},
+	{`print(p.Greet("hi"), " ", p.Describe());`, "hi, bob base 7"},
+	{`old = p.Rename("amy"); print(old, " ", p.Name);`, "bob amy"},
+	{`p.Name = "eve"; p.ID = 8; print(p.Greet("hey"), " ", p.Describe());`, "hey, eve base 8"},
+	{`f = p.Greet; print(f("yo"));`, "yo, bob"},
+	{`print(v.Name, " ", v.Greet("hi"));`, "bob hi, bob"},
+	{`p.age;`, "<eval>:第1行:第1列: cannot refer to unexported field age of *gogogo.Person"},
+	{`p.Nope;`, "<eval>:第1行:第1列: type *gogogo.Person has no field or method Nope"},
+	{`v.Name = "x";`, "<eval>:第1行:第1列: cannot assign to field Name of unaddressable gogogo.Person"},
+	{`x = nil; x.Name;`, "<eval>:第1行:第10列: cannot access member Name of nil"},
+	{`delete(ages, "a"); print(len(ages));`, "1"},
+	{`delete(ages, 98);`, "<eval>:第1行:第1列: invalid key for delete(): cannot use int64 as string"},
+}
+
+// goPackageTests 用 DefinePackage 注册的函数, 常量和类型
+var goPackageTests = []struct {
+	src  string
+	want string
+}{
+	{`print(strings.ToUpper("abc"), " ", strings.Repeat("x", 3));`, "ABC xxx"},
+	{`b = strings.Builder(); b.WriteString("hi"); print(b.String());`, "hi"},
+	{`q = geo.Point({"X": 1, "Y": 2}); q.X = 10; print(q.Sum(), " ", geo.Point().X, " ", geo.Origin.Y);`, "12 0 0"},
+	{`geo.Point({"Z": 1});`, "<eval>:第1行:第1列: type *gogogo.Point has no field or method Z"},
+	{`geo.Point(1, 2);`, "<eval>:第1行:第1列: too many arguments to conversion to gogogo.Point"},
+	{`strings.Nope;`, "<eval>:第1行:第1列: undefined: strings.Nope"},
+	{`print(strings.Join(["a", "b"], "-"), " ", strings.Repeat("x", 2.0));`, "a-b xx"},
+	{`strings.Repeat("x");`, "<eval>:第1行:第1列: wrong number of arguments in call to func(string, int) string: have 1, want 2"},
+	{`strings.Repeat("x", "a");`, "<eval>:第1行:第1列: argument 2 in call to func(string, int) string: cannot use string as int"},
+	{`strings.Join([1], "-");`, "<eval>:第1行:第1列: argument 1 in call to func([]string, string) string: cannot use []interface {} as []string: element 0: cannot use int64 as string"},
+	{`print(strconv.Atoi("12") + 1);`, "13"},
+	{`strconv.Atoi("x");`, `<eval>:第1行:第1列: strconv.Atoi: parsing "x": invalid syntax`},
+}
+
+type Point struct{ X, Y int }
+
+func (p *Point) Sum() int { return p.X + p.Y }
+
+// evalGo 在定义了 Go 值和包的新解释器中执行, 返回输出和错误
+func evalGo(src string, bytecode bool) string {
+	var out bytes.Buffer
+	in := New()
+	in.Bytecode = bytecode
+	in.Stdout = &out
+	in.Stderr = &out
+	p := &Person{Base: Base{ID: 7}, Name: "bob", Tags: []string{"x", "y"}}
+	in.Set("p", p)
+	in.Set("v", *p)
+	in.Set("ages", map[string]int{"a": 1, "b": 2})
+	in.DefinePackage("strings", map[string]interface{}{"ToUpper": strings.ToUpper, "Repeat": strings.Repeat, "Join": strings.Join},
+		map[string]interface{}{"Builder": strings.Builder{}})
+	in.DefinePackage("strconv", map[string]interface{}{"Atoi": strconv.Atoi}, nil)
+	in.DefinePackage("geo", map[string]interface{}{"Origin": Point{}}, map[string]interface{}{"Point": Point{}})
+	if _, err := in.Eval(src); err != nil {
+		in.PrintError(err)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// TestGoValues 两个引擎读写 Go 值的字段, 调用它的方法和注册的包
+func TestGoValues(t *testing.T) {
+	tests := append(goValueTests, goPackageTests...)
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			if got := evalGo(tt.src, bytecode); got != tt.want {
+				t.Errorf("%s bytecode=%v:\ngot:  %s\nwant: %s", tt.src, bytecode, got, tt.want)
+			}
+		}
+	}
+}
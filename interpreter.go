@@ -0,0 +1,199 @@
+// Package gogogo 在 Go 程序中嵌入脚本解释器
+package gogogo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+// Interpreter 脚本解释器, 多次执行共用同一个全局环境, 不能并发使用
+type Interpreter struct {
+	Stdout   io.Writer // print 的输出, 默认 os.Stdout
+	Stderr   io.Writer // PrintError 的输出, 默认 os.Stderr
+	Bytecode bool      // 用字节码虚拟机执行, 默认遍历语法树
+
+	env *vm.Env
+}
+
+// New 新的解释器, 只定义了 print, len, delete, keys, 需要标准库时调用 ImportStdlib
+func New() *Interpreter {
+	in := &Interpreter{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		env:    vm.NewEnv(),
+	}
+	in.loadBuiltins()
+	return in
+}
+
+// SetLimits 设置执行限制, 超过时返回 *vm.InterruptError
+func (in *Interpreter) SetLimits(l vm.Limits) {
+	in.env.SetLimits(l)
+}
+
+// SetImportPath 设置 import 的搜索路径, 先在导入模块的文件所在目录找, 然后依次在 dirs 中找
+func (in *Interpreter) SetImportPath(dirs ...string) {
+	in.env.SetImportPath(dirs)
+}
+
+// SetHook 设置调试钩子, 每条语句执行前调用, 只对遍历语法树的引擎有效
+func (in *Interpreter) SetHook(h vm.Hook) {
+	in.env.SetHook(h)
+}
+
+// Eval 执行源码, 返回最后一条语句的值
+func (in *Interpreter) Eval(source string) (interface{}, error) {
+	return in.eval(context.Background(), "", source)
+}
+
+// EvalContext 执行源码, ctx 被取消时返回 *vm.InterruptError
+func (in *Interpreter) EvalContext(ctx context.Context, source string) (interface{}, error) {
+	return in.eval(ctx, "", source)
+}
+
+// EvalFile 执行源文件
+func (in *Interpreter) EvalFile(filename string) (interface{}, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return in.eval(context.Background(), filename, string(b))
+}
+
+func (in *Interpreter) eval(ctx context.Context, filename, source string) (interface{}, error) {
+	t, err := parse.ParseFile(filename, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv reflect.Value
+	if in.Bytecode {
+		rv, err = vm.RunCompiledContext(ctx, t.Root, in.env)
+	} else {
+		rv, err = vm.RunContext(ctx, t.Root, in.env)
+	}
+	if err != nil {
+		// 顶层代码所在的文件
+		if ee, ok := err.(*vm.Error); ok {
+			for i := range ee.Trace {
+				if ee.Trace[i].File == "" {
+					ee.Trace[i].File = filename
+				}
+			}
+		}
+		return nil, err
+	}
+	return toGo(rv), nil
+}
+
+// Call 调用脚本中的函数, 参数和返回值都是普通的 Go 值
+func (in *Interpreter) Call(name string, args ...interface{}) (interface{}, error) {
+	f, err := in.env.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	rvs := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		rvs[i] = reflect.ValueOf(arg)
+	}
+	rv, err := vm.Call(f, rvs...)
+	if err != nil {
+		return nil, err
+	}
+	return toGo(rv), nil
+}
+
+// Set 定义全局变量, Go 函数可以在脚本中直接调用, 结构体的字段和方法用 . 访问, 需要赋值字段时传指针
+func (in *Interpreter) Set(name string, v interface{}) {
+	in.env.Define(name, v)
+}
+
+// DefinePackage 把 Go 包定义为名字空间, 例如
+//
+//	in.DefinePackage("strings", map[string]interface{}{"ToUpper": strings.ToUpper},
+//		map[string]interface{}{"Builder": strings.Builder{}})
+//
+// 脚本中用 strings.ToUpper(s) 调用函数, 用 strings.Builder() 构造类型
+func (in *Interpreter) DefinePackage(name string, values map[string]interface{}, types map[string]interface{}) error {
+	_, err := in.env.DefinePackage(name, values, types)
+	return err
+}
+
+// Get 取全局变量的值
+func (in *Interpreter) Get(name string) (interface{}, error) {
+	rv, err := in.env.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return toGo(rv), nil
+}
+
+// toGo 转换为普通的 Go 值, 脚本函数转换为 func(...interface{}) (interface{}, error)
+func toGo(rv reflect.Value) interface{} {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv == vm.NilValue || !rv.CanInterface() {
+		return nil
+	}
+	if f, ok := rv.Interface().(vm.Func); ok {
+		return func(args ...interface{}) (interface{}, error) {
+			rvs := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				rvs[i] = reflect.ValueOf(arg)
+			}
+			rv, err := f(rvs...)
+			if err != nil {
+				return nil, err
+			}
+			return toGo(rv), nil
+		}
+	}
+	return rv.Interface()
+}
+
+// PrintError 把错误的位置和调用栈打印到 Stderr
+func (in *Interpreter) PrintError(err error) {
+	w := in.Stderr
+	switch e := err.(type) {
+	case *vm.Error:
+		file := ""
+		if len(e.Trace) > 0 {
+			file = e.Trace[0].File
+		}
+		fmt.Fprintf(w, "%s:第%d行:第%d列: %s\n", sourceName(file), e.Pos.Line, e.Pos.Column, err)
+		// 只有顶层代码时不打印调用栈
+		if len(e.Trace) < 2 {
+			return
+		}
+		fmt.Fprintln(w, "调用栈:")
+		for _, f := range e.Trace {
+			fmt.Fprintf(w, "\t%s\t%s:第%d行:第%d列\n", f.Func, sourceName(f.File), f.Pos.Line, f.Pos.Column)
+		}
+	case *vm.InterruptError:
+		fmt.Fprintf(w, "第%d行:第%d列: %s\n", e.Pos.Line, e.Pos.Column, err)
+	case *parse.Error:
+		fmt.Fprintf(w, "%s:第%d行:第%d列: %s\n", sourceName(e.Filename), e.Pos.Line, e.Pos.Column, err)
+	case parse.ErrorList:
+		for _, pe := range e {
+			in.PrintError(pe)
+		}
+	default:
+		fmt.Fprintln(w, err)
+	}
+}
+
+// sourceName 没有文件名的源码来自 Eval
+func sourceName(file string) string {
+	if file == "" {
+		return "<eval>"
+	}
+	return file
+}
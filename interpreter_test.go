@@ -0,0 +1,95 @@
+package gogogo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lth-go/gogogo/vm"
+)
+
+// TestInterpreter 两个引擎在同一个全局环境中执行, Go 和脚本互相调用
+func TestInterpreter(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		in := New()
+		in.Bytecode = bytecode
+		in.Set("add", func(a, b int64) int64 { return a + b })
+
+		v, err := in.Eval("x = add(1, 2); x * 2;")
+		if err != nil || v != int64(6) {
+			t.Errorf("bytecode=%v: Eval got %v, %v, want 6", bytecode, v, err)
+		}
+		if v, err := in.Get("x"); err != nil || v != int64(3) {
+			t.Errorf("bytecode=%v: Get got %v, %v, want 3", bytecode, v, err)
+		}
+
+		if _, err := in.Eval(`func greet(name) { return "hi " + name; }`); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := in.Call("greet", "bob"); err != nil || v != "hi bob" {
+			t.Errorf("bytecode=%v: Call got %v, %v, want hi bob", bytecode, v, err)
+		}
+
+		v, err = in.Eval("double = func(a) { return a * 2; }; double;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, ok := v.(func(...interface{}) (interface{}, error))
+		if !ok {
+			t.Fatalf("bytecode=%v: got %s, want script function", bytecode, reflect.TypeOf(v))
+		}
+		if v, err := f(int64(4)); err != nil || v != int64(8) {
+			t.Errorf("bytecode=%v: script function got %v, %v, want 8", bytecode, v, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = in.EvalContext(ctx, "for i = 0; true; i = i + 1 {}")
+		var ie *vm.InterruptError
+		if !errors.As(err, &ie) || !errors.Is(err, context.Canceled) {
+			t.Errorf("bytecode=%v: EvalContext got %v, want canceled", bytecode, err)
+		}
+	}
+}
+
+// TestBuiltins 不导入标准库也能用 len, delete, keys
+func TestBuiltins(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		in := New()
+		in.Bytecode = bytecode
+		v, err := in.Eval(`m = {"a": 1, "b": 2}; delete(m, "a"); len(keys(m)) + len("ab");`)
+		if err != nil || v != int64(3) {
+			t.Errorf("bytecode=%v: got %v, %v, want 3", bytecode, v, err)
+		}
+		if _, err := in.Eval("sqrt(4);"); err == nil {
+			t.Errorf("bytecode=%v: sqrt defined without ImportStdlib", bytecode)
+		}
+	}
+}
+
+// TestEvalValue Eval 的结果是最后一条语句的值, 赋值和循环的值为 nil
+func TestEvalValue(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1; 2;", int64(2)},
+		{"1; x = 42;", nil},
+		{"if true { 4; }", int64(4)},
+		{"if false { 4; }", nil},
+		{"for i in 2 { i; }", nil},
+		{"switch 1 { case 1: 6; }", int64(6)},
+		{"try { 7; } finally { 8; }", int64(7)},
+		{"try { throw 1; } catch { 9; }", int64(9)},
+	}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			in := New()
+			in.Bytecode = bytecode
+			if v, err := in.Eval(tt.src); err != nil || v != tt.want {
+				t.Errorf("%s bytecode=%v: got %v, %v, want %v", tt.src, bytecode, v, err, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package lsp
+
+import (
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// 文档分析
+//////////////////////////////
+
+// document 打开的文档和分析结果
+type document struct {
+	uri   string
+	text  string
+	lines [][]rune
+	tree  *parse.Tree
+	err   error // 语法错误或编译错误
+
+	tokens []tok
+	global *scope
+	scopes []*scope // 所有函数的作用域, 按出现顺序排列
+}
+
+type tok struct {
+	typ parse.TokenType
+	lit string
+	pos parse.Position
+}
+
+// definition 函数定义, 变量第一次赋值或者函数的参数
+type definition struct {
+	name  string
+	kind  int
+	pos   parse.Position // 名字的位置
+	fn    *parse.FuncExpr
+	imp   *parse.ImportStmt
+	param bool
+	scope *scope // 定义所在的作用域
+	body  *scope // 函数的作用域
+}
+
+// scope 函数的作用域, 范围从 func 到 }
+type scope struct {
+	fn     *parse.FuncExpr
+	start  parse.Position
+	end    parse.Position
+	parent *scope
+	defs   []*definition // 按出现顺序排列
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text}
+	for _, line := range strings.Split(text, "\n") {
+		d.lines = append(d.lines, []rune(line))
+	}
+	d.scan()
+
+	d.tree, d.err = parse.ParseFile(uri, text)
+	if d.err == nil {
+		// 编译可以发现循环外的 break 之类的错误
+		_, d.err = vm.Compile(d.tree.Root)
+	}
+
+	d.global = &scope{end: parse.Position{Line: 1 << 30}}
+	if d.tree != nil {
+		d.walkStmts(d.tree.Root, d.global)
+	}
+	return d
+}
+
+// scan 记录所有 token 的位置, 语法树中没有参数和函数名的位置
+func (d *document) scan() {
+	s := parse.NewScanner(d.text)
+	var last parse.Position
+	for {
+		typ, lit, pos, err := s.Scan()
+		if typ == parse.EOF || err != nil && pos == last {
+			return
+		}
+		last = pos
+		if err == nil {
+			d.tokens = append(d.tokens, tok{typ: typ, lit: lit, pos: pos})
+		}
+	}
+}
+
+// tokenAt 位置在 pos 的 token 的下标
+func (d *document) tokenAt(pos parse.Position) int {
+	for i, t := range d.tokens {
+		if t.pos == pos {
+			return i
+		}
+	}
+	return -1
+}
+
+// funcScope 根据 token 找出函数名, 参数的位置和函数体的范围
+func (d *document) funcScope(fn *parse.FuncExpr, parent *scope) (*scope, parse.Position, []parse.Position) {
+	sc := &scope{fn: fn, start: fn.Position(), end: fn.Position(), parent: parent}
+	namePos := fn.Position()
+	var params []parse.Position
+
+	i := d.tokenAt(fn.Position())
+	if i < 0 {
+		return sc, namePos, params
+	}
+	i++
+	if fn.Name != "" && i < len(d.tokens) && d.tokens[i].typ == parse.IDENTI {
+		namePos = d.tokens[i].pos
+		i++
+	}
+	for ; i < len(d.tokens) && d.tokens[i].typ != parse.LC; i++ {
+		if d.tokens[i].typ == parse.IDENTI {
+			params = append(params, d.tokens[i].pos)
+		}
+	}
+	// 成对的 {} 找到函数体结束的位置
+	depth := 0
+	for ; i < len(d.tokens); i++ {
+		switch d.tokens[i].typ {
+		case parse.LC:
+			depth++
+		case parse.RC:
+			depth--
+			if depth == 0 {
+				sc.end = d.tokens[i].pos
+				return sc, namePos, params
+			}
+		}
+	}
+	sc.end = parse.Position{Line: 1 << 30}
+	return sc, namePos, params
+}
+
+func (d *document) define(sc *scope, name string, kind int, pos parse.Position) *definition {
+	def := &definition{name: name, kind: kind, pos: pos, scope: sc}
+	sc.defs = append(sc.defs, def)
+	return def
+}
+
+// lookup 在作用域和外层作用域中找定义
+func lookup(sc *scope, name string, pos parse.Position) *definition {
+	for s := sc; s != nil; s = s.parent {
+		for _, def := range s.defs {
+			if def.name != name {
+				continue
+			}
+			// 同一个作用域中还没有赋值时用外层的变量
+			if s == sc && before(pos, def.pos) {
+				break
+			}
+			return def
+		}
+	}
+	return nil
+}
+
+func (d *document) walkStmts(stmts []parse.Stmt, sc *scope) {
+	for _, stmt := range stmts {
+		d.walkStmt(stmt, sc)
+	}
+}
+
+func (d *document) walkStmt(stmt parse.Stmt, sc *scope) {
+	switch s := stmt.(type) {
+	case *parse.ExprStmt:
+		d.walkExpr(s.Expr, sc)
+	case *parse.LetsStmt:
+		d.walkLets(s.Lhss, s.Rhss, sc)
+	case *parse.IfStmt:
+		d.walkExpr(s.Condition, sc)
+		d.walkStmts(s.Do, sc)
+		d.walkStmts(s.Elif, sc)
+		d.walkStmts(s.Else, sc)
+	case *parse.ForStmt:
+		d.walkExpr(s.Initial, sc)
+		d.walkExpr(s.Condition, sc)
+		d.walkExpr(s.After, sc)
+		d.walkStmts(s.Do, sc)
+	case *parse.ForInStmt:
+		d.walkExpr(s.Expr, sc)
+		for i, name := range s.Vars {
+			d.define(sc, name, SymbolVariable, d.forVarPos(s, i))
+		}
+		d.walkStmts(s.Do, sc)
+	case *parse.ReturnStmt:
+		d.walkExpr(s.Expr, sc)
+	case *parse.TryStmt:
+		d.walkStmts(s.Try, sc)
+		if s.Var != "" {
+			d.define(sc, s.Var, SymbolVariable, d.catchVarPos(s))
+		}
+		d.walkStmts(s.Catch, sc)
+		d.walkStmts(s.Finally, sc)
+	case *parse.ThrowStmt:
+		d.walkExpr(s.Expr, sc)
+	case *parse.SwitchStmt:
+		d.walkExpr(s.Expr, sc)
+		for _, c := range s.Cases {
+			for _, e := range c.Exprs {
+				d.walkExpr(e, sc)
+			}
+			d.walkStmts(c.Do, sc)
+		}
+	case *parse.ImportStmt:
+		// 模块名是路径字符串的最后一部分
+		pos := s.Position()
+		if i := d.tokenAt(pos); i >= 0 && i+1 < len(d.tokens) && d.tokens[i+1].typ == parse.STRING {
+			pos = d.tokens[i+1].pos
+			pos.Column += 1 + len([]rune(s.Path)) - len([]rune(path.Base(s.Path)))
+		}
+		d.define(sc, s.Name, SymbolModule, pos).imp = s
+	}
+}
+
+// catchVarPos catch 后面变量名的位置, 跳过 try 块中的 {}
+func (d *document) catchVarPos(s *parse.TryStmt) parse.Position {
+	i := d.tokenAt(s.Position())
+	if i < 0 {
+		return s.Position()
+	}
+	depth := 0
+	for ; i+1 < len(d.tokens); i++ {
+		switch d.tokens[i].typ {
+		case parse.LC:
+			depth++
+		case parse.RC:
+			depth--
+		case parse.CATCH:
+			if depth == 0 && d.tokens[i+1].typ == parse.IDENTI {
+				return d.tokens[i+1].pos
+			}
+		}
+	}
+	return s.Position()
+}
+
+// forVarPos for 后面第 n 个变量的位置, 变量之间是逗号
+func (d *document) forVarPos(s *parse.ForInStmt, n int) parse.Position {
+	i := d.tokenAt(s.Position())
+	if i < 0 || i+1+2*n >= len(d.tokens) {
+		return s.Position()
+	}
+	return d.tokens[i+1+2*n].pos
+}
+
+// walkLets 没有定义过的变量第一次赋值时是定义
+func (d *document) walkLets(lhss, rhss []parse.Expr, sc *scope) {
+	for _, rhs := range rhss {
+		d.walkExpr(rhs, sc)
+	}
+	for _, lhs := range lhss {
+		ident, ok := lhs.(*parse.IdentExpr)
+		if !ok {
+			d.walkExpr(lhs, sc)
+			continue
+		}
+		if lookup(sc, ident.Lit, ident.Position()) == nil {
+			d.define(sc, ident.Lit, SymbolVariable, ident.Position())
+		}
+	}
+}
+
+func (d *document) walkExprs(exprs []parse.Expr, sc *scope) {
+	for _, expr := range exprs {
+		d.walkExpr(expr, sc)
+	}
+}
+
+func (d *document) walkExpr(expr parse.Expr, sc *scope) {
+	switch e := expr.(type) {
+	case *parse.FuncExpr:
+		body, namePos, params := d.funcScope(e, sc)
+		d.scopes = append(d.scopes, body)
+		if e.Name != "" {
+			def := d.define(sc, e.Name, SymbolFunction, namePos)
+			def.fn = e
+			def.body = body
+		}
+		for i, pos := range params {
+			if i < len(e.Args) {
+				d.define(body, e.Args[i], SymbolVariable, pos).param = true
+			}
+		}
+		d.walkStmts(e.Stmts, body)
+	case *parse.LetsExpr:
+		d.walkLets(e.Lhss, e.Rhss, sc)
+	case *parse.UnaryExpr:
+		d.walkExpr(e.Expr, sc)
+	case *parse.ParenExpr:
+		d.walkExpr(e.SubExpr, sc)
+	case *parse.BinOpExpr:
+		d.walkExpr(e.Lhs, sc)
+		d.walkExpr(e.Rhs, sc)
+	case *parse.CallExpr:
+		d.walkExpr(e.Func, sc)
+		d.walkExprs(e.SubExprs, sc)
+	case *parse.ArrayExpr:
+		d.walkExprs(e.Exprs, sc)
+	case *parse.MapExpr:
+		d.walkExprs(e.Keys, sc)
+		d.walkExprs(e.Values, sc)
+	case *parse.IndexExpr:
+		d.walkExpr(e.Value, sc)
+		d.walkExpr(e.Index, sc)
+	case *parse.MemberExpr:
+		d.walkExpr(e.Expr, sc)
+	case *parse.InterpExpr:
+		d.walkExprs(e.Exprs, sc)
+	case *parse.SliceExpr:
+		d.walkExpr(e.Value, sc)
+		d.walkExpr(e.Begin, sc)
+		d.walkExpr(e.End, sc)
+	}
+}
+
+// identAt 光标所在的标识符, 不包括 . 后面的名字
+func (d *document) identAt(pos parse.Position) (tok, bool) {
+	for i, t := range d.tokens {
+		if t.typ != parse.IDENTI || t.pos.Line != pos.Line {
+			continue
+		}
+		if i > 0 && d.tokens[i-1].typ == parse.DOT {
+			continue
+		}
+		if t.pos.Column <= pos.Column && pos.Column <= t.pos.Column+len([]rune(t.lit)) {
+			return t, true
+		}
+	}
+	return tok{}, false
+}
+
+// scopeAt 包含位置的最里层作用域
+func (d *document) scopeAt(pos parse.Position) *scope {
+	sc := d.global
+	for _, s := range d.scopes {
+		// 后出现的作用域在前面的里面或者后面
+		if before(s.start, pos) && before(pos, s.end) {
+			sc = s
+		}
+	}
+	return sc
+}
+
+// definitionOf 标识符的定义, 光标在定义上时返回它自己
+func (d *document) definitionOf(t tok) *definition {
+	for _, s := range append([]*scope{d.global}, d.scopes...) {
+		for _, def := range s.defs {
+			if def.pos == t.pos {
+				return def
+			}
+		}
+	}
+	return lookup(d.scopeAt(t.pos), t.lit, t.pos)
+}
+
+func before(a, b parse.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+//////////////////////////////
+// 位置转换
+//////////////////////////////
+
+// toLSP 语法树的行列从 1 开始按字符计算, LSP 从 0 开始按 UTF-16 计算
+func (d *document) toLSP(pos parse.Position) Position {
+	line := pos.Line - 1
+	if line < 0 {
+		return Position{}
+	}
+	if line >= len(d.lines) {
+		return Position{Line: line}
+	}
+	runes := d.lines[line]
+	col := pos.Column - 1
+	if col > len(runes) {
+		col = len(runes)
+	}
+	if col < 0 {
+		col = 0
+	}
+	return Position{Line: line, Character: len(utf16.Encode(runes[:col]))}
+}
+
+func (d *document) fromLSP(pos Position) parse.Position {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return parse.Position{Line: pos.Line + 1, Column: pos.Character + 1}
+	}
+	runes := d.lines[pos.Line]
+	n, col := 0, 0
+	for col < len(runes) && n < pos.Character {
+		n += len(utf16.Encode(runes[col : col+1]))
+		col++
+	}
+	return parse.Position{Line: pos.Line + 1, Column: col + 1}
+}
+
+// rangeOf 从 pos 开始 n 个字符的范围
+func (d *document) rangeOf(pos parse.Position, n int) Range {
+	end := pos
+	end.Column += n
+	return Range{Start: d.toLSP(pos), End: d.toLSP(end)}
+}
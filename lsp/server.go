@@ -0,0 +1,306 @@
+// Package lsp 编辑器使用的 Language Server Protocol 服务, 通过标准输入输出通信
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lth-go/gogogo"
+	"github.com/lth-go/gogogo/parse"
+	"github.com/lth-go/gogogo/vm"
+)
+
+// Server LSP 服务
+type Server struct {
+	in   *gogogo.Interpreter // 提供内置函数的签名
+	docs map[string]*document
+
+	w        io.Writer
+	mu       sync.Mutex // 保护 w
+	shutdown bool
+}
+
+// NewServer 新的服务, 悬停提示使用解释器中全局函数的签名
+func NewServer(in *gogogo.Interpreter) *Server {
+	return &Server{in: in, docs: make(map[string]*document)}
+}
+
+// Serve 处理请求直到收到 exit 或者输入结束
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	tr := textproto.NewReader(bufio.NewReader(r))
+	for {
+		msg, err := readMessage(tr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			// 不知道请求的 id, 回复的 id 为 null
+			null := json.RawMessage("null")
+			s.reply(&null, nil, &responseError{Code: codeParseError, Message: "invalid JSON"})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		s.handle(msg)
+	}
+}
+
+// maxMessageSize 消息的最大长度, 避免按客户端给的长度分配过多内存
+const maxMessageSize = 64 << 20
+
+// readMessage 读取一条消息, 格式是 Content-Length 头加 JSON
+func readMessage(tr *textproto.Reader) (*message, error) {
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if n < 0 || n > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length: %d, at most %d bytes", n, maxMessageSize)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(tr.R, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (s *Server) write(msg *message) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err *responseError) {
+	if id == nil && err == nil {
+		return
+	}
+
+	// 没有结果时也要有 result 字段
+	if result == nil && err == nil {
+		result = json.RawMessage("null")
+	}
+	s.write(&message{ID: id, Result: result, Error: err})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	s.write(&message{Method: method, Params: b})
+}
+
+func (s *Server) handle(msg *message) {
+	var result interface{}
+	var err error
+
+	if s.shutdown {
+		s.reply(msg.ID, nil, &responseError{Code: codeInvalidRequest, Message: "server is shut down"})
+		return
+	}
+
+	switch msg.Method {
+	case "initialize":
+		result = &InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       textDocumentSyncOptions{OpenClose: true, Change: 1, Save: saveOptions{IncludeText: true}},
+				DefinitionProvider:     true,
+				HoverProvider:          true,
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: serverInfo{Name: "gogogo"},
+		}
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			s.open(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			// 只分析, 保存时才报告错误
+			uri := params.TextDocument.URI
+			s.docs[uri] = newDocument(uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			uri := params.TextDocument.URI
+			if params.Text != nil {
+				s.open(uri, *params.Text)
+			} else if d, ok := s.docs[uri]; ok {
+				s.publish(d)
+			}
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			delete(s.docs, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		}
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.definition(params)
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.hover(params)
+		}
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.symbols(params)
+		}
+	default:
+		// 不支持的通知不用回复
+		if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+			s.reply(msg.ID, nil, &responseError{Code: codeMethodNotFound, Message: "method not supported: " + msg.Method})
+		}
+		return
+	}
+
+	if err != nil {
+		s.reply(msg.ID, nil, &responseError{Code: codeInvalidParams, Message: err.Error()})
+		return
+	}
+	s.reply(msg.ID, result, nil)
+}
+
+func (s *Server) open(uri, text string) {
+	d := newDocument(uri, text)
+	s.docs[uri] = d
+	s.publish(d)
+}
+
+// publish 报告语法错误和编译错误
+func (s *Server) publish(d *document) {
+	diags := []Diagnostic{}
+	add := func(pos parse.Position, message string) {
+		diags = append(diags, Diagnostic{
+			Range:    d.rangeOf(pos, 1),
+			Severity: SeverityError,
+			Source:   "gogogo",
+			Message:  message,
+		})
+	}
+	switch e := d.err.(type) {
+	case parse.ErrorList:
+		for _, pe := range e {
+			add(pe.Pos, pe.Message)
+		}
+	case *parse.Error:
+		add(e.Pos, e.Message)
+	case *vm.Error:
+		add(e.Pos, e.Message)
+	}
+	s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: d.uri, Diagnostics: diags})
+}
+
+func (s *Server) definition(params TextDocumentPositionParams) interface{} {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	t, ok := d.identAt(d.fromLSP(params.Position))
+	if !ok {
+		return nil
+	}
+	def := d.definitionOf(t)
+	if def == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.rangeOf(def.pos, len([]rune(def.name)))}
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) interface{} {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	t, ok := d.identAt(d.fromLSP(params.Position))
+	if !ok {
+		return nil
+	}
+
+	var text string
+	if def := d.definitionOf(t); def != nil {
+		if def.fn != nil {
+			text = "```\nfunc " + def.name + "(" + strings.Join(def.fn.Args, ", ") + ")\n```"
+		} else if def.imp != nil {
+			text = "```\nimport \"" + def.imp.Path + "\"\n```"
+		} else {
+			text = "```\nvar " + def.name + "\n```"
+		}
+	} else if sig, ok := s.in.Signature(t.lit); ok {
+		// 第一行是签名, 后面是说明
+		lines := strings.SplitN(sig, "\n", 2)
+		text = "```\n" + lines[0] + "\n```"
+		if len(lines) > 1 {
+			text += "\n\n" + lines[1]
+		}
+	} else {
+		return nil
+	}
+
+	r := d.rangeOf(t.pos, len([]rune(t.lit)))
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+func (s *Server) symbols(params DocumentSymbolParams) interface{} {
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	return d.symbols(d.global)
+}
+
+// symbols 作用域中的函数和变量, 函数内部的定义作为子节点
+func (d *document) symbols(sc *scope) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, def := range sc.defs {
+		// 参数不算
+		if def.param {
+			continue
+		}
+		sel := d.rangeOf(def.pos, len([]rune(def.name)))
+		sym := DocumentSymbol{Name: def.name, Kind: def.kind, Range: sel, SelectionRange: sel}
+		if def.fn != nil {
+			sym.Detail = "func(" + strings.Join(def.fn.Args, ", ") + ")"
+			end := def.body.end
+			end.Column++
+			sym.Range = Range{Start: d.toLSP(def.body.start), End: d.toLSP(end)}
+			sym.Children = d.symbols(def.body)
+		}
+		syms = append(syms, sym)
+	}
+	return syms
+}
@@ -0,0 +1,95 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/lth-go/gogogo"
+)
+
+// session 依次发送 msgs, 返回服务发出的所有消息
+func session(t *testing.T, msgs ...string) []string {
+	var in, out bytes.Buffer
+	for _, m := range msgs {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := NewServer(gogogo.New()).Serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	tr := textproto.NewReader(bufio.NewReader(&out))
+	for {
+		msg, err := readMessage(tr)
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+}
+
+// TestServer 打开有语法错误的文档, 查询定义, 悬停提示和符号
+func TestServer(t *testing.T) {
+	src := "func add(a, b) {\n    return a + b;\n}\nx = add(1, 2);\ny = (;\n"
+	open, _ := json.Marshal(map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///a.gg", "version": 1, "text": src},
+	})
+	got := session(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":`+string(open)+`}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition","params":{"textDocument":{"uri":"file:///a.gg"},"position":{"line":3,"character":5}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///a.gg"},"position":{"line":3,"character":5}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"file:///a.gg"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"unknown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"definitionProvider":true,"documentSymbolProvider":true,"hoverProvider":true,"textDocumentSync":{"change":1,"openClose":true,"save":{"includeText":true}}},"serverInfo":{"name":"gogogo"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.gg","diagnostics":[{"range":{"start":{"line":4,"character":5},"end":{"line":4,"character":6}},"severity":1,"source":"gogogo","message":"syntax error: unexpected ';', expecting expression"}]}}`,
+		`{"jsonrpc":"2.0","id":2,"result":{"range":{"end":{"character":8,"line":0},"start":{"character":5,"line":0}},"uri":"file:///a.gg"}}`,
+		"{\"jsonrpc\":\"2.0\",\"id\":3,\"result\":{\"contents\":{\"kind\":\"markdown\",\"value\":\"```\\nfunc add(a, b)\\n```\"},\"range\":{\"end\":{\"character\":7,\"line\":3},\"start\":{\"character\":4,\"line\":3}}}}",
+		`{"jsonrpc":"2.0","id":4,"result":[{"detail":"func(a, b)","kind":12,"name":"add","range":{"end":{"character":1,"line":2},"start":{"character":0,"line":0}},"selectionRange":{"end":{"character":8,"line":0},"start":{"character":5,"line":0}}},{"kind":13,"name":"x","range":{"end":{"character":1,"line":3},"start":{"character":0,"line":3}},"selectionRange":{"end":{"character":1,"line":3},"start":{"character":0,"line":3}}}]}`,
+		`{"jsonrpc":"2.0","id":5,"error":{"code":-32601,"message":"method not supported: unknown"}}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d:\ngot:  %s\nwant: %s", i, got[i], want[i])
+		}
+	}
+}
+
+// TestInvalidMessage 不能解析的 JSON 回复 id 为 null 的错误, 过长的消息直接报错
+func TestInvalidMessage(t *testing.T) {
+	// session 解析回复时会丢掉 null 的 id, 直接比较输出
+	var out bytes.Buffer
+	in := strings.NewReader("Content-Length: 11\r\n\r\n{\"jsonrpc\":")
+	if err := NewServer(gogogo.New()).Serve(in, &out); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasSuffix(got, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON"}}`) {
+		t.Errorf("got %q, want reply with null id", got)
+	}
+
+	for _, n := range []string{"-1", "67108865"} {
+		tr := textproto.NewReader(bufio.NewReader(strings.NewReader("Content-Length: " + n + "\r\n\r\n{}")))
+		if _, err := readMessage(tr); err == nil || !strings.Contains(err.Error(), "invalid Content-Length") {
+			t.Errorf("Content-Length %s: got %v, want invalid Content-Length", n, err)
+		}
+	}
+}
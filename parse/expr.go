@@ -1,5 +1,7 @@
 package parse
 
+import "strings"
+
 // Expr provides all of interfaces for expression.
 type Expr interface {
 	Pos
@@ -28,12 +30,28 @@ func (e *NumberExpr) expr() {
 type StringExpr struct {
 	ExprImpl
 	Lit string
+	Raw string // 源码中的写法, 包括引号
 }
 
 func (e *StringExpr) expr() {
 	print("* StringExpr: ", e.Lit, "\n")
 }
 
+// InterpExpr provide string interpolation expression, like "a ${b} c".
+type InterpExpr struct {
+	ExprImpl
+	Lits  []string // 表达式之间的字符串, 比 Exprs 多一个
+	Raws  []string // Lits 在源码中的写法, 包括引号和 ${, }
+	Exprs []Expr
+}
+
+func (e *InterpExpr) expr() {
+	print("* InterpExpr: ", strings.Join(e.Lits, "${}"), "\n")
+	for _, expr := range e.Exprs {
+		expr.expr()
+	}
+}
+
 // IdentExpr provide identity expression.
 type IdentExpr struct {
 	ExprImpl
@@ -44,7 +62,7 @@ func (e *IdentExpr) expr() {
 	print("* IdentExpr: ", e.Lit, "\n")
 }
 
-// UnaryExpr provide unary minus expression. ex: -1, ^1, ~1.
+// UnaryExpr provide unary expression. ex: -1, !ok, ^1.
 type UnaryExpr struct {
 	ExprImpl
 	Operator string
@@ -87,6 +105,7 @@ type FuncExpr struct {
 	Name  string
 	Stmts []Stmt
 	Args  []string
+	File  string // 定义函数的源文件
 }
 
 func (e *FuncExpr) expr() {
@@ -98,20 +117,94 @@ func (e *FuncExpr) expr() {
 	rangeStmt(e.Stmts)
 }
 
-// CallExpr ...
+// CallExpr provide calling expression. ex: foo(1), make_adder(1)(2)
 type CallExpr struct {
 	ExprImpl
-	Func     interface{}
+	Func     Expr // 被调用的表达式, 为 nil 时按 Name 查找函数
 	Name     string
 	SubExprs []Expr
 }
 
 func (e *CallExpr) expr() {
 	print("* CallExpr: :", e.Name, "\n")
+	if e.Func != nil {
+		print("** Func:\n")
+		e.Func.expr()
+	}
 	print("** Args:")
 	rangeExpr(e.SubExprs)
 }
 
+// ArrayExpr provide array expression. ex: [1, 2, 3]
+type ArrayExpr struct {
+	ExprImpl
+	Exprs []Expr
+}
+
+func (e *ArrayExpr) expr() {
+	print("* ArrayExpr: \n")
+	rangeExpr(e.Exprs)
+}
+
+// MapExpr provide map expression. ex: {"a": 1, "b": 2}
+type MapExpr struct {
+	ExprImpl
+	Keys   []Expr
+	Values []Expr
+}
+
+func (e *MapExpr) expr() {
+	print("* MapExpr: \n")
+	for i := range e.Keys {
+		e.Keys[i].expr()
+		e.Values[i].expr()
+	}
+}
+
+// IndexExpr provide index expression. ex: a[1], m["a"]
+type IndexExpr struct {
+	ExprImpl
+	Value Expr
+	Index Expr
+}
+
+func (e *IndexExpr) expr() {
+	print("* IndexExpr: \n")
+	e.Value.expr()
+	e.Index.expr()
+}
+
+// MemberExpr provide member expression. ex: lib.name
+type MemberExpr struct {
+	ExprImpl
+	Expr Expr
+	Name string
+}
+
+func (e *MemberExpr) expr() {
+	print("* MemberExpr: ", e.Name, "\n")
+	e.Expr.expr()
+}
+
+// SliceExpr provide slice expression. ex: a[1:3], a[:3], a[1:]
+type SliceExpr struct {
+	ExprImpl
+	Value Expr
+	Begin Expr
+	End   Expr
+}
+
+func (e *SliceExpr) expr() {
+	print("* SliceExpr: \n")
+	e.Value.expr()
+	if e.Begin != nil {
+		e.Begin.expr()
+	}
+	if e.End != nil {
+		e.End.expr()
+	}
+}
+
 // ConstExpr provide expression for constant variable.
 type ConstExpr struct {
 	ExprImpl
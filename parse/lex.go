@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -17,6 +18,9 @@ const (
 	NUMBER                       // 5 数字
 	NIL                          // 36 NIL
 	STRING                       // 6 字符串
+	STRBEGIN                     // "...${ 插值字符串的开头
+	STRMID                       // }...${ 插值字符串的中间部分
+	STREND                       // }..." 插值字符串的结尾
 	DOT                          // 8 .
 	SPACE                        // 7 空格
 	LP                           // 15 (
@@ -32,6 +36,7 @@ const (
 	MINUS                        // 11 -
 	MULTIPLY                     // 12 *
 	DIVIDE                       // 13 /
+	MOD                          // %
 	ANDAND                       // &&
 	AND                          // 25 &
 	OROR                         // ||
@@ -44,6 +49,7 @@ const (
 	LT                           // 32 <
 	LE                           // 33 <=
 	EXCLAMATION                  // 34 !
+	XOR                          // ^
 	KEYWORD                      // 35 关键字分隔
 	FUNC                         // 37 FUNC
 	RETURN                       // 38 RETURN
@@ -53,6 +59,16 @@ const (
 	ELIF                         // 42 ELIF
 	ELSE                         // 41 ELSE
 	FOR                          // 39 FOR
+	IN                           // IN
+	IMPORT                       // IMPORT
+	TRY                          // TRY
+	CATCH                        // CATCH
+	FINALLY                      // FINALLY
+	THROW                        // THROW
+	SWITCH                       // SWITCH
+	CASE                         // CASE
+	DEFAULT                      // DEFAULT
+	FALLTHROUGH                  // FALLTHROUGH
 )
 
 var opName = map[string]TokenType{
@@ -64,6 +80,16 @@ var opName = map[string]TokenType{
 	"elif":     ELIF,
 	"else":     ELSE,
 	"for":      FOR,
+	"in":       IN,
+	"import":   IMPORT,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"switch":   SWITCH,
+	"case":     CASE,
+	"default":  DEFAULT,
+	"fallthrough": FALLTHROUGH,
 	"true":     BOOL,
 	"false":    BOOL,
 	"nil":      NIL,
@@ -83,23 +109,129 @@ var symbolMap = map[rune]TokenType{
 	'-': MINUS,
 	'*': MULTIPLY,
 	'/': DIVIDE,
+	'%': MOD,
+	'^': XOR,
+	'.': DOT,
 }
 
+var errCommentNotTerminated = errors.New("comment not terminated")
+
+var errRawStringNotTerminated = errors.New("raw string literal not terminated")
+
+// scanError 位置不在 token 开头的词法错误
+type scanError struct {
+	pos Position
+	msg string
+}
+
+func (e *scanError) Error() string {
+	return e.msg
+}
+
+var tokenNames = map[TokenType]string{
+	ERROR:       "error",
+	EOF:         "EOF",
+	EOL:         "newline",
+	BOOL:        "bool",
+	IDENTI:      "identifier",
+	NUMBER:      "number",
+	NIL:         "'nil'",
+	STRING:      "string",
+	STRBEGIN:    "string",
+	STRMID:      "string",
+	STREND:      "string",
+	DOT:         "'.'",
+	SPACE:       "space",
+	LP:          "'('",
+	RP:          "')'",
+	LC:          "'{'",
+	RC:          "'}'",
+	LB:          "'['",
+	RB:          "']'",
+	SEMICOLON:   "';'",
+	COLON:       "':'",
+	COMMA:       "','",
+	PLUS:        "'+'",
+	MINUS:       "'-'",
+	MULTIPLY:    "'*'",
+	DIVIDE:      "'/'",
+	MOD:         "'%'",
+	ANDAND:      "'&&'",
+	AND:         "'&'",
+	OROR:        "'||'",
+	OR:          "'|'",
+	EQ:          "'='",
+	EQEQ:        "'=='",
+	NEQ:         "'!='",
+	GT:          "'>'",
+	GE:          "'>='",
+	LT:          "'<'",
+	LE:          "'<='",
+	EXCLAMATION: "'!'",
+	XOR:         "'^'",
+	FUNC:        "'func'",
+	RETURN:      "'return'",
+	BREAK:       "'break'",
+	CONTINUE:    "'continue'",
+	IF:          "'if'",
+	ELIF:        "'elif'",
+	ELSE:        "'else'",
+	FOR:         "'for'",
+	IN:          "'in'",
+	IMPORT:      "'import'",
+	TRY:         "'try'",
+	CATCH:       "'catch'",
+	FINALLY:     "'finally'",
+	THROW:       "'throw'",
+	SWITCH:      "'switch'",
+	CASE:        "'case'",
+	DEFAULT:     "'default'",
+	FALLTHROUGH: "'fallthrough'",
+}
+
+func (typ TokenType) String() string {
+	if name, ok := tokenNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("token(%d)", int(typ))
+}
+
+// Error 语法错误
 type Error struct {
 	Message  string
 	Pos      Position
 	Filename string
-	Fatal    bool
+	Fatal    bool // 词法错误
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
 
+// ErrorList 语法分析中的所有错误, 按出现顺序排列
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
 type token struct {
 	PosImpl
 	typ TokenType
 	val string
+	raw string // 字符串在源码中的写法
+}
+
+// Comment 注释, Text 包括 //, # 和 /* */
+type Comment struct {
+	PosImpl
+	Text string
 }
 
 type Scanner struct {
@@ -107,11 +239,31 @@ type Scanner struct {
 	offset   int
 	lineHead int
 	line     int
+	start    int        // 最后一个 token 开始的位置
+	interps  []int      // 字符串插值中未闭合的 { 数, 每层 ${ 一个
+	Comments []*Comment // 扫描过的注释
+}
+
+// NewScanner 扫描源码, 给需要 token 位置的工具使用
+func NewScanner(src string) *Scanner {
+	return &Scanner{src: []rune(src)}
 }
 
 func (s *Scanner) Scan() (typ TokenType, lit string, pos Position, err error) {
-	s.skipBlank()
+	// 跳过注释
+	for s.skipBlank(); s.isComment(); s.skipBlank() {
+		pos = s.pos()
+		lit, err = s.scanComment()
+		if err != nil {
+			typ = ERROR
+			return
+		}
+		c := &Comment{Text: lit}
+		c.SetPosition(pos)
+		s.Comments = append(s.Comments, c)
+	}
 	pos = s.pos()
+	s.start = s.offset
 	switch ch := s.peek(); {
 	case isLetter(ch):
 		lit, err = s.scanIdentifier()
@@ -130,8 +282,16 @@ func (s *Scanner) Scan() (typ TokenType, lit string, pos Position, err error) {
 			return
 		}
 	case ch == '"':
+		typ, lit, err = s.scanString(true)
+		if e, ok := err.(*scanError); ok {
+			pos = e.pos
+		}
+		if err != nil {
+			return
+		}
+	case ch == '`':
 		typ = STRING
-		lit, err = s.scanString()
+		lit, err = s.scanRawString()
 		if err != nil {
 			return
 		}
@@ -208,13 +368,32 @@ func (s *Scanner) Scan() (typ TokenType, lit string, pos Position, err error) {
 		case '\n':
 			typ = EOL
 			lit = "EOL"
-		case ',', ':', ';', '(', ')', '{', '}', '[', ']', '+', '-', '*', '/':
+		case '{', '}':
+			n := len(s.interps)
+			// 插值表达式结束, 继续扫描字符串
+			if ch == '}' && n > 0 && s.interps[n-1] == 0 {
+				s.interps = s.interps[:n-1]
+				typ, lit, err = s.scanString(false)
+				if e, ok := err.(*scanError); ok {
+					pos = e.pos
+				}
+				return
+			}
+			if n > 0 && ch == '{' {
+				s.interps[n-1]++
+			} else if n > 0 {
+				s.interps[n-1]--
+			}
+			typ = symbolMap[ch]
+			lit = string(ch)
+		case ',', ':', ';', '(', ')', '[', ']', '+', '-', '*', '/', '%', '^', '.':
 			typ = symbolMap[ch]
 			lit = string(ch)
 		default:
 			err = fmt.Errorf(`syntax error "%s"`, string(ch))
 			typ = ERROR
 			lit = string(ch)
+			s.next()
 			return
 		}
 		s.next()
@@ -237,48 +416,268 @@ func (s *Scanner) scanIdentifier() (string, error) {
 	return string(ret), nil
 }
 
+// scanNumber 数字, 语法和 Go 相同: 0x, 0o, 0b 前缀, 小数, 指数和 _ 分隔.
+// 返回源码中的写法, 由执行时转换成数值
 func (s *Scanner) scanNumber() (string, error) {
 	var ret []rune
+	accept := func(digit func(rune) bool) int {
+		n := 0
+		for digit(s.peek()) || s.peek() == '_' {
+			if s.peek() != '_' {
+				n++
+			}
+			ret = append(ret, s.peek())
+			s.next()
+		}
+		return n
+	}
 
-	ch := s.peek()
-	ret = append(ret, ch)
-	s.next()
+	if s.peek() == '0' {
+		if base, name := numberBase(s.peekNext()); base != 0 {
+			ret = append(ret, s.peek(), s.peekNext())
+			s.next()
+			s.next()
+			// 先把可能的数字都读进来, 然后报告不合法的数字
+			if accept(isHexDigit) == 0 {
+				return "", fmt.Errorf("%s literal has no digits", name)
+			}
+			for _, ch := range ret[2:] {
+				if ch != '_' && digitVal(ch) >= uint32(base) {
+					return "", fmt.Errorf("invalid digit %q in %s literal", ch, name)
+				}
+			}
+			return s.checkNumber(ret, isHexDigit)
+		}
+	}
 
-	if ch == '0' && s.peek() == '.' {
-		ret = append(ret, ch)
+	accept(isDigit)
+	float := false
+	// 小数点后面必须是数字, 否则是取成员
+	if s.peek() == '.' && isDigit(s.peekNext()) {
+		float = true
+		ret = append(ret, '.')
 		s.next()
-	} else if ch == '0' && isDigit(s.peek()) {
-		return "", errors.New("数字不能以0开头")
+		accept(isDigit)
 	}
-
-	for isDigit(s.peek()) {
+	if s.peek() == 'e' || s.peek() == 'E' {
+		float = true
 		ret = append(ret, s.peek())
 		s.next()
+		if s.peek() == '+' || s.peek() == '-' {
+			ret = append(ret, s.peek())
+			s.next()
+		}
+		if accept(isDigit) == 0 {
+			return "", errors.New("exponent has no digits")
+		}
 	}
+	if !float && len(ret) > 1 && ret[0] == '0' {
+		return "", errors.New("invalid leading zero in decimal literal, use 0o for octal")
+	}
+	return s.checkNumber(ret, isDigit)
+}
 
-	if isLetter(s.peek()) {
+// numberBase 0 后面的前缀对应的进制, 不是前缀时返回 0
+func numberBase(ch rune) (int, string) {
+	switch ch {
+	case 'x', 'X':
+		return 16, "hexadecimal"
+	case 'o', 'O':
+		return 8, "octal"
+	case 'b', 'B':
+		return 2, "binary"
+	}
+	return 0, ""
+}
+
+// checkNumber _ 只能在数字之间或者前缀和数字之间, 数字后面不能紧跟字母
+func (s *Scanner) checkNumber(ret []rune, digit func(rune) bool) (string, error) {
+	if isLetter(s.peek()) || isDigit(s.peek()) {
 		return "", errors.New("identifier starts immediately after numeric literal")
 	}
-	return string(ret), nil
+	lit := string(ret)
+	for i, ch := range ret {
+		if ch != '_' {
+			continue
+		}
+		prefix := i == 2 && ret[0] == '0' && isLetter(ret[1])
+		if i == 0 || i == len(ret)-1 || !prefix && !digit(ret[i-1]) || !digit(ret[i+1]) {
+			return "", errors.New("'_' must separate successive digits")
+		}
+	}
+	return lit, nil
 }
-func (s *Scanner) scanString() (string, error) {
-	var ret []rune
 
-eos:
+// scanString 双引号字符串, 转义字符和 Go 相同, \x 和八进制转义是一个字节.
+// 遇到 ${ 时返回前面的部分, 后面是插值表达式的 token, 对应的 } 之后再扫描剩下的部分.
+// begin 为真时从开头的 " 开始, 否则从插值表达式结束的 } 开始
+func (s *Scanner) scanString(begin bool) (TokenType, string, error) {
+	var ret []byte
+
+	typ, interp := STREND, STRMID
+	if begin {
+		typ, interp = STRING, STRBEGIN
+	}
+	s.next()
 	for {
-		s.next()
-		switch s.peek() {
+		switch ch := s.peek(); ch {
 		case '\n':
-			return "", errors.New("Unexpected EOL")
+			return typ, "", errors.New("Unexpected EOL")
 		case -1:
-			return "", errors.New("Unexpected EOF")
+			return typ, "", errors.New("Unexpected EOF")
 		case '"':
 			s.next()
-			break eos
+			return typ, string(ret), nil
+		case '$':
+			s.next()
+			if s.peek() == '{' {
+				s.next()
+				s.interps = append(s.interps, 0)
+				return interp, string(ret), nil
+			}
+			ret = append(ret, '$')
+		case '\\':
+			pos := s.pos()
+			var err error
+			ret, err = s.scanEscape(ret)
+			if err != nil {
+				// 跳过字符串剩下的部分, 后面的 token 不受影响
+				for s.peek() != '"' && !isEOL(s.peek()) {
+					s.next()
+				}
+				if s.peek() == '"' {
+					s.next()
+				}
+				return typ, "", &scanError{pos: pos, msg: err.Error()}
+			}
 		default:
+			ret = utf8.AppendRune(ret, ch)
+			s.next()
+		}
+	}
+}
+
+var simpleEscapes = map[rune]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v', '\\': '\\', '"': '"',
+	'$': '$', // \${ 不是插值
+}
+
+// scanEscape 解码 \ 开头的转义字符, 追加到 ret 后面
+func (s *Scanner) scanEscape(ret []byte) ([]byte, error) {
+	s.next()
+	ch := s.peek()
+	if b, ok := simpleEscapes[ch]; ok {
+		s.next()
+		return append(ret, b), nil
+	}
+
+	var n int
+	var base, max uint32
+	switch ch {
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n, base, max = 3, 8, 255
+	case 'x':
+		s.next()
+		n, base, max = 2, 16, 255
+	case 'u':
+		s.next()
+		n, base, max = 4, 16, unicode.MaxRune
+	case 'U':
+		s.next()
+		n, base, max = 8, 16, unicode.MaxRune
+	case '\n', -1:
+		return ret, errors.New("escape sequence not terminated")
+	default:
+		return ret, errors.New("unknown escape sequence")
+	}
+
+	var x uint32
+	for i := 0; i < n; i++ {
+		ch := s.peek()
+		d := digitVal(ch)
+		if d >= base {
+			if ch == '"' || isEOL(ch) {
+				return ret, errors.New("escape sequence not terminated")
+			}
+			return ret, fmt.Errorf("illegal character %#U in escape sequence", ch)
+		}
+		x = x*base + d
+		s.next()
+	}
+	if x > max || 0xD800 <= x && x < 0xE000 && max == unicode.MaxRune {
+		if base == 8 {
+			return ret, errors.New("octal escape value > 255")
+		}
+		return ret, errors.New("escape sequence is invalid Unicode code point")
+	}
+	if max == 255 {
+		return append(ret, byte(x)), nil
+	}
+	return utf8.AppendRune(ret, rune(x)), nil
+}
+
+// scanRawString 反引号字符串, 可以跨行, 不处理转义, 和 Go 一样去掉 \r
+func (s *Scanner) scanRawString() (string, error) {
+	var ret []rune
+
+	s.next()
+	for {
+		switch ch := s.peek(); ch {
+		case -1:
+			return "", errRawStringNotTerminated
+		case '`':
+			s.next()
+			return string(ret), nil
+		case '\r':
+			s.next()
+		default:
+			ret = append(ret, ch)
+			s.next()
+		}
+	}
+}
+
+func (s *Scanner) isComment() bool {
+	switch s.peek() {
+	case '#':
+		return true
+	case '/':
+		next := s.peekNext()
+		return next == '/' || next == '*'
+	}
+	return false
+}
+
+// scanComment 扫描注释, 行注释不包括结尾的换行
+func (s *Scanner) scanComment() (string, error) {
+	var ret []rune
+
+	// 块注释
+	if s.peek() == '/' && s.peekNext() == '*' {
+		ret = append(ret, '/', '*')
+		s.next()
+		s.next()
+		for {
+			switch s.peek() {
+			case -1:
+				return string(ret), errCommentNotTerminated
+			case '*':
+				if s.peekNext() == '/' {
+					s.next()
+					s.next()
+					return string(append(ret, '*', '/')), nil
+				}
+			}
 			ret = append(ret, s.peek())
+			s.next()
 		}
 	}
+
+	// 行注释
+	for !isEOL(s.peek()) {
+		ret = append(ret, s.peek())
+		s.next()
+	}
 	return string(ret), nil
 }
 
@@ -293,6 +692,13 @@ func (s *Scanner) peek() rune {
 	return s.src[s.offset]
 }
 
+func (s *Scanner) peekNext() rune {
+	if s.offset+1 >= len(s.src) {
+		return -1
+	}
+	return s.src[s.offset+1]
+}
+
 func (s *Scanner) next() {
 	if !s.reachEOF() {
 		if s.peek() == '\n' {
@@ -341,6 +747,23 @@ func isDigit(r rune) bool {
 	return '0' <= r && r <= '9'
 }
 
+// digitVal 十六进制数字的值, 不是数字时返回 16
+func digitVal(r rune) uint32 {
+	switch {
+	case '0' <= r && r <= '9':
+		return uint32(r - '0')
+	case 'a' <= r && r <= 'f':
+		return uint32(r - 'a' + 10)
+	case 'A' <= r && r <= 'F':
+		return uint32(r - 'A' + 10)
+	}
+	return 16
+}
+
+func isHexDigit(r rune) bool {
+	return digitVal(r) < 16
+}
+
 func isEOL(r rune) bool {
 	return r == '\n' || r == -1
 }
@@ -369,10 +792,14 @@ func lex(src string) *lexer {
 func (l *lexer) run() {
 	for {
 		tok, lit, pos, err := l.s.Scan()
+		t := token{typ: tok, val: lit}
+		if tok == STRING || tok == STRBEGIN || tok == STRMID || tok == STREND {
+			t.raw = string(l.s.src[l.s.start:l.s.offset])
+		}
 		if err != nil {
-			panic(fmt.Sprintf("%s", err.Error()))
+			// 词法错误交给语法分析报告, 然后继续扫描
+			t = token{typ: ERROR, val: err.Error()}
 		}
-		t := token{typ: tok, val: lit}
 		t.SetPosition(pos)
 
 		l.tokens <- t
@@ -385,7 +812,38 @@ func (l *lexer) run() {
 
 }
 
+// Incomplete 判断源码的括号, 块注释或反引号字符串是否还没有结束, 交互模式用来判断是否需要继续输入
+func Incomplete(src string) bool {
+	s := &Scanner{src: []rune(src)}
+	depth := 0
+	for {
+		typ, _, _, err := s.Scan()
+		if err != nil {
+			return err == errCommentNotTerminated || err == errRawStringNotTerminated
+		}
+		switch typ {
+		case LP, LB, LC, STRBEGIN:
+			depth++
+		case RP, RB, RC, STREND:
+			depth--
+		case EOF:
+			return depth > 0
+		}
+	}
+}
+
 func (l *lexer) nextToken() token {
-	token := <-l.tokens
+	token, ok := <-l.tokens
+	if !ok {
+		token.typ = EOF
+	}
 	return token
 }
+
+// drain 语法分析出错后取完剩下的 token, 结束词法分析
+func (l *lexer) drain() {
+	go func() {
+		for range l.tokens {
+		}
+	}()
+}
@@ -2,27 +2,44 @@ package parse
 
 import (
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
 )
 
 // Tree 语法树
 type Tree struct {
 	Root      []Stmt
+	Comments  []*Comment // 源码中所有的注释, 按出现顺序排列
+	Filename  string     // 源文件名, 记录在错误和函数中
 	text      string
 	lex       *lexer
 	token     [2]token
 	peekCount int
+	last      token // 最后消耗的 token, 不包括换行
+	errors    ErrorList
 }
 
 //////////////////////////////
 // start
 //////////////////////////////
 
+// Debug 为 true 时打印语法树
+var Debug = false
+
 // Parse 解析文本并返回语法树
 func Parse(text string) (*Tree, error) {
-	t := &Tree{text: text}
+	return ParseFile("", text)
+}
+
+// ParseFile 解析文件内容并返回语法树, 文件名记录在错误和函数中
+func ParseFile(filename, text string) (*Tree, error) {
+	t := &Tree{text: text, Filename: filename}
 
 	// 词法分析
-	print("# TOKEN\n")
+	if Debug {
+		print("# TOKEN\n")
+	}
 	t.lex = lex(text)
 
 	// 语法分析
@@ -31,41 +48,178 @@ func Parse(text string) (*Tree, error) {
 	return t, err
 }
 
-// Parse 语法分析,生成语法树
-func (t *Tree) Parse() (*Tree, error) {
+// Parse 语法分析,生成语法树, 出错时返回 ErrorList
+func (t *Tree) Parse() (tree *Tree, err error) {
+	defer t.recover(&err)
 
 	for t.peek().typ != EOF {
-		n := t.parseStmt()
+		n := t.parseStmtOrSync(true)
 		if n != nil {
 			t.Root = append(t.Root, n)
 		}
 	}
-	// 打印语法树
-	print("\n# PARSE\n")
-	for _, stmt := range t.Root {
-		stmt.stmt()
-	}
 
+	t.Comments = t.lex.s.Comments
 	t.lex = nil
+
+	if len(t.errors) > 0 {
+		return t, t.errors
+	}
+
+	// 打印语法树
+	if Debug {
+		print("\n# PARSE\n")
+		for _, stmt := range t.Root {
+			stmt.stmt()
+		}
+	}
 	return t, nil
 }
 
+// recover 语法分析中意外的 panic 也作为错误返回, 不让调用方崩溃
+func (t *Tree) recover(errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	e, ok := r.(*Error)
+	if !ok {
+		e = &Error{Message: fmt.Sprintf("internal parser error: %v", r), Pos: t.token[0].Position(), Fatal: true}
+	}
+	t.addError(e)
+	*errp = t.errors
+	if t.lex != nil {
+		t.lex.drain()
+		t.lex = nil
+	}
+}
+
+//////////////////////////////
+// 错误
+//////////////////////////////
+
+// errorf 在 token 的位置报告语法错误
+func (t *Tree) errorf(tok token, format string, args ...interface{}) {
+	panic(&Error{Message: fmt.Sprintf(format, args...), Pos: tok.Position()})
+}
+
+// unexpected 报告意外的 token
+func (t *Tree) unexpected(tok token, expected string) {
+	t.errorf(tok, "syntax error: unexpected %s, expecting %s", describe(tok), expected)
+}
+
+// addError 记录错误, 同一行只记录第一个错误
+func (t *Tree) addError(e *Error) {
+	e.Filename = t.Filename
+	if n := len(t.errors); n > 0 && t.errors[n-1].Pos.Line == e.Pos.Line {
+		return
+	}
+	t.errors = append(t.errors, e)
+}
+
+// parseStmtOrSync 解析一条语句, 出错时记录错误并跳到下一条语句
+func (t *Tree) parseStmtOrSync(toplevel bool) (stmt Stmt) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		e, ok := r.(*Error)
+		if !ok {
+			panic(r)
+		}
+		t.addError(e)
+		t.sync(toplevel)
+		stmt = nil
+	}()
+	if !toplevel && t.peek().typ == IMPORT {
+		t.errorf(t.peek(), "syntax error: import must be at top level")
+	}
+	stmt = t.parseStmt()
+	if stmt != nil {
+		stmt.SetEnd(t.last.Position())
+	}
+	return stmt
+}
+
+// sync 跳到 ; 之后或者语句块的 } 处, 跳过中间成对的 {}
+func (t *Tree) sync(toplevel bool) {
+	depth := 0
+	for {
+		switch t.peek().typ {
+		case EOF:
+			return
+		case SEMICOLON:
+			t.next()
+			if depth == 0 {
+				return
+			}
+		case LC:
+			t.next()
+			depth++
+		case RC:
+			if depth == 0 {
+				// 顶层多余的 }
+				if toplevel {
+					t.next()
+				}
+				return
+			}
+			t.next()
+			depth--
+			if depth == 0 {
+				return
+			}
+		default:
+			t.next()
+		}
+	}
+}
+
+func describe(tok token) string {
+	switch tok.typ {
+	case IDENTI:
+		return fmt.Sprintf("identifier %s", tok.val)
+	case NUMBER:
+		return fmt.Sprintf("number %s", tok.val)
+	case STRING, STRBEGIN, STRMID, STREND:
+		return fmt.Sprintf("string %q", tok.val)
+	case BOOL:
+		return tok.val
+	}
+	return tok.typ.String()
+}
+
 //////////////////////////////
 // token获取以及移动
 //////////////////////////////
 
 var numToken = 1
 
+// nextToken 从词法分析取 token, 词法错误记录后跳过
+func (t *Tree) nextToken() token {
+	for {
+		tok := t.lex.nextToken()
+		if tok.typ != ERROR {
+			return tok
+		}
+		t.addError(&Error{Message: tok.val, Pos: tok.Position(), Fatal: true})
+	}
+}
+
 // 返回下一个token
 func (t *Tree) next() token {
 	if t.peekCount > 0 {
 		t.peekCount--
 	} else {
-		t.token[0] = t.lex.nextToken()
+		t.token[0] = t.nextToken()
 	}
 
 	//print(fmt.Sprintf("\n[%v]：(%v, %v)\n", numToken, t.token[t.peekCount].typ, t.token[t.peekCount].val))
 	numToken++
+	if tok := t.token[t.peekCount]; tok.typ != EOL {
+		t.last = tok
+	}
 	return t.token[t.peekCount]
 }
 
@@ -75,13 +229,13 @@ func (t *Tree) peek() token {
 		return t.token[t.peekCount-1]
 	}
 	t.peekCount = 1
-	t.token[0] = t.lex.nextToken()
+	t.token[0] = t.nextToken()
 	return t.token[0]
 }
 
 func (t *Tree) peekNotNone() (tok token) {
 	for {
-		tok := t.peek()
+		tok = t.peek()
 		typ := tok.typ
 
 		if typ != EOL && typ != SPACE {
@@ -98,22 +252,22 @@ func (t *Tree) peek2() token {
 	if t.peekCount == 1 {
 		t.peekCount++
 		t.token[1] = t.token[0]
-		t.token[0] = t.lex.nextToken()
+		t.token[0] = t.nextToken()
 	} else if t.peekCount == 0 {
 		t.peekCount = 2
-		t.token[1] = t.lex.nextToken()
-		t.token[0] = t.lex.nextToken()
+		t.token[1] = t.nextToken()
+		t.token[0] = t.nextToken()
 	}
 	return t.token[t.peekCount-2]
 }
 
 // 判断是否类型匹配
 func (t *Tree) match(typ TokenType) token {
-	token := t.next()
+	token := t.peek()
 	if token.typ != typ {
-		panic(fmt.Sprintf("match: 类型不对, give type is: (%v), match type is: (%v, %v)", typ, token.typ, token.val))
+		t.unexpected(token, typ.String())
 	}
-	return token
+	return t.next()
 }
 
 //////////////////////////////
@@ -139,6 +293,12 @@ func (t *Tree) newForStmt() *ForStmt {
 	return stmt
 
 }
+func (t *Tree) newForInStmt() *ForInStmt {
+	tok := t.peek()
+	stmt := &ForInStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
 func (t *Tree) newReturnStmt() *ReturnStmt {
 	tok := t.peek()
 	stmt := &ReturnStmt{}
@@ -161,6 +321,48 @@ func (t *Tree) newContinueStmt() *ContinueStmt {
 
 }
 
+func (t *Tree) newTryStmt() *TryStmt {
+	tok := t.peek()
+	stmt := &TryStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
+func (t *Tree) newSwitchStmt() *SwitchStmt {
+	tok := t.peek()
+	stmt := &SwitchStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
+func (t *Tree) newCaseStmt() *CaseStmt {
+	tok := t.peek()
+	stmt := &CaseStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
+func (t *Tree) newFallthroughStmt() *FallthroughStmt {
+	tok := t.peek()
+	stmt := &FallthroughStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
+func (t *Tree) newThrowStmt() *ThrowStmt {
+	tok := t.peek()
+	stmt := &ThrowStmt{}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
+func (t *Tree) newImportStmt() *ImportStmt {
+	tok := t.peek()
+	stmt := &ImportStmt{File: t.Filename}
+	stmt.SetPosition(tok.Position())
+	return stmt
+}
+
 func (t *Tree) newLetsStmt() *LetsStmt {
 	tok := t.peek()
 	stmt := &LetsStmt{}
@@ -184,6 +386,12 @@ func (t *Tree) newStringExpr() *StringExpr {
 	expr.SetPosition(tok.Position())
 	return expr
 }
+func (t *Tree) newInterpExpr() *InterpExpr {
+	tok := t.peek()
+	expr := &InterpExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
 func (t *Tree) newIdentExpr() *IdentExpr {
 	tok := t.peek()
 	expr := &IdentExpr{}
@@ -216,7 +424,7 @@ func (t *Tree) newConstExpr() *ConstExpr {
 }
 func (t *Tree) newFuncExpr() *FuncExpr {
 	tok := t.peek()
-	expr := &FuncExpr{}
+	expr := &FuncExpr{File: t.Filename}
 	expr.SetPosition(tok.Position())
 	return expr
 }
@@ -228,6 +436,41 @@ func (t *Tree) newCallExpr() *CallExpr {
 	return expr
 }
 
+func (t *Tree) newArrayExpr() *ArrayExpr {
+	tok := t.peek()
+	expr := &ArrayExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
+
+func (t *Tree) newMapExpr() *MapExpr {
+	tok := t.peek()
+	expr := &MapExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
+
+func (t *Tree) newIndexExpr() *IndexExpr {
+	tok := t.peek()
+	expr := &IndexExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
+
+func (t *Tree) newSliceExpr() *SliceExpr {
+	tok := t.peek()
+	expr := &SliceExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
+
+func (t *Tree) newMemberExpr() *MemberExpr {
+	tok := t.peek()
+	expr := &MemberExpr{}
+	expr.SetPosition(tok.Position())
+	return expr
+}
+
 func (t *Tree) newLetsExpr() *LetsExpr {
 	tok := t.peek()
 	expr := &LetsExpr{}
@@ -269,6 +512,22 @@ func (t *Tree) parseStmt() Stmt {
 	case CONTINUE:
 		n := t.parseContinueStmt()
 		return n
+	case IMPORT:
+		n := t.parseImportStmt()
+		return n
+	case TRY:
+		n := t.parseTryStmt()
+		return n
+	case THROW:
+		n := t.parseThrowStmt()
+		return n
+	case SWITCH:
+		n := t.parseSwitchStmt()
+		return n
+	case FALLTHROUGH:
+		// 只能是 case 的最后一条语句, 由 parseCase 解析
+		t.errorf(token, "fallthrough statement out of place")
+		return nil
 	default:
 		n := t.newExprStmt()
 
@@ -350,6 +609,7 @@ func (t *Tree) parseElif() Stmt {
 	n.Condition = t.parseExpr()
 
 	n.Do = t.parseBlock()
+	n.SetEnd(t.last.Position())
 
 	return n
 
@@ -357,13 +617,41 @@ func (t *Tree) parseElif() Stmt {
 
 
 // ## FOR
+
+// parseFor parse like
+//for i = 0; i < 10; i = i + 1 {}
+//for cond {}
+//for {}
+//for k, v in expr {}
 func (t *Tree) parseFor() Stmt {
 	n := t.newForStmt()
 
 	t.match(FOR)
 
+	// 省略所有部分时一直循环
+	if t.peek().typ == LC {
+		n.Do = t.parseBlock()
+		return n
+	}
+	if t.peek().typ == IDENTI {
+		if typ := t.peek2().typ; typ == COMMA || typ == IN {
+			return t.parseForIn(n.Position())
+		}
+	}
+
 	if t.peek().typ != SEMICOLON {
-		n.Initial = t.parseLetsExpr()
+		expr := t.parseExpr()
+		switch t.peek().typ {
+		case EQ, COMMA:
+			n.Initial = t.parseLetsRest(expr)
+		case LC:
+			// 只有条件
+			n.Condition = expr
+			n.Do = t.parseBlock()
+			return n
+		default:
+			n.Initial = expr
+		}
 	}
 	t.match(SEMICOLON)
 
@@ -381,12 +669,38 @@ func (t *Tree) parseFor() Stmt {
 	return n
 
 }
+
+// parseForIn parse like
+//for k, v in expr {
+//    DO
+//}
+func (t *Tree) parseForIn(pos Position) Stmt {
+	n := t.newForInStmt()
+	n.SetPosition(pos)
+
+	n.Vars = append(n.Vars, t.match(IDENTI).val)
+	if t.peek().typ == COMMA {
+		t.match(COMMA)
+		n.Vars = append(n.Vars, t.match(IDENTI).val)
+	}
+	t.match(IN)
+	n.Expr = t.parseExpr()
+
+	n.Do = t.parseBlock()
+
+	return n
+}
+
 func (t *Tree) parseLetsExpr() Expr {
-	n := t.newLetsExpr()
+	return t.parseLetsRest(t.parseExpr())
+}
 
-	newExpr := t.parseExpr()
+// parseLetsRest 第一个左值已经解析过
+func (t *Tree) parseLetsRest(first Expr) Expr {
+	n := t.newLetsExpr()
+	n.SetPosition(first.Position())
 
-	n.Lhss = append(n.Lhss, newExpr)
+	n.Lhss = append(n.Lhss, first)
 
 	for t.peek().typ == COMMA {
 		t.match(COMMA)
@@ -403,6 +717,9 @@ func (t *Tree) parseLetsExpr() Expr {
 func (t *Tree) parseBreakStmt() Stmt {
 	n := t.newBreakStmt()
 	t.match(BREAK)
+	if t.peek().typ == SEMICOLON {
+		t.match(SEMICOLON)
+	}
 	return n
 }
 
@@ -410,9 +727,219 @@ func (t *Tree) parseBreakStmt() Stmt {
 func (t *Tree) parseContinueStmt() Stmt {
 	n := t.newContinueStmt()
 	t.match(CONTINUE)
+	if t.peek().typ == SEMICOLON {
+		t.match(SEMICOLON)
+	}
+	return n
+}
+
+// ## try
+
+// parseTryStmt parse like
+//try {
+//    DO
+//} catch e {
+//    DO
+//} finally {
+//    DO
+//}
+func (t *Tree) parseTryStmt() Stmt {
+	n := t.newTryStmt()
+	t.match(TRY)
+
+	n.Try = t.parseBlock()
+
+	if t.peek().typ == CATCH {
+		t.match(CATCH)
+		if t.peek().typ == IDENTI {
+			n.Var = t.match(IDENTI).val
+		}
+		n.Catch = t.parseBlock()
+	}
+	if t.peek().typ == FINALLY {
+		t.match(FINALLY)
+		n.Finally = t.parseBlock()
+	}
+	if n.Catch == nil && n.Finally == nil {
+		t.unexpected(t.peek(), "catch or finally")
+	}
+	return n
+}
+
+// ## throw
+func (t *Tree) parseThrowStmt() Stmt {
+	n := t.newThrowStmt()
+	t.match(THROW)
+
+	n.Expr = t.parseExpr()
+	t.match(SEMICOLON)
+
+	return n
+}
+
+// ## switch
+
+// parseSwitchStmt parse like
+//switch expr {
+//case a, b:
+//    DO
+//default:
+//    DO
+//}
+func (t *Tree) parseSwitchStmt() Stmt {
+	n := t.newSwitchStmt()
+	t.match(SWITCH)
+
+	n.Expr = t.parseExpr()
+
+	t.match(LC)
+	for typ := t.peekNotNone().typ; typ != RC && typ != EOF; typ = t.peekNotNone().typ {
+		n.Cases = append(n.Cases, t.parseCase())
+	}
+	t.match(RC)
+
+	t.checkSwitch(n)
+	return n
+}
+
+func (t *Tree) parseCase() *CaseStmt {
+	n := t.newCaseStmt()
+
+	switch tok := t.peek(); tok.typ {
+	case CASE:
+		t.match(CASE)
+		n.Exprs = t.parseExprList()
+	case DEFAULT:
+		t.match(DEFAULT)
+	default:
+		t.unexpected(tok, "case or default")
+	}
+	t.match(COLON)
+
+	n.Do = []Stmt{}
+	for typ := t.peekNotNone().typ; typ != CASE && typ != DEFAULT && typ != RC && typ != EOF; typ = t.peekNotNone().typ {
+		if typ == FALLTHROUGH {
+			n.Do = append(n.Do, t.parseFallthroughStmt())
+			continue
+		}
+		if statement := t.parseStmtOrSync(false); statement != nil {
+			n.Do = append(n.Do, statement)
+		}
+	}
+	n.SetEnd(t.last.Position())
+
+	return n
+}
+
+// ## fallthrough
+func (t *Tree) parseFallthroughStmt() Stmt {
+	n := t.newFallthroughStmt()
+	t.match(FALLTHROUGH)
+	if t.peek().typ == SEMICOLON {
+		t.match(SEMICOLON)
+	}
+	n.SetEnd(t.last.Position())
+
+	if typ := t.peekNotNone().typ; typ != CASE && typ != DEFAULT && typ != RC {
+		t.addError(&Error{Message: "fallthrough statement out of place", Pos: n.Position()})
+	}
+	return n
+}
+
+// checkSwitch 检查 default 的个数, 最后的 fallthrough 和重复的常量 case
+func (t *Tree) checkSwitch(n *SwitchStmt) {
+	hasDefault := false
+	seen := map[string]bool{}
+	for i, c := range n.Cases {
+		if c.Exprs == nil {
+			if hasDefault {
+				t.addError(&Error{Message: "multiple defaults in switch", Pos: c.Position()})
+			}
+			hasDefault = true
+		}
+		for _, e := range c.Exprs {
+			key, lit, ok := constKey(e)
+			if !ok {
+				continue
+			}
+			if seen[key] {
+				t.addError(&Error{Message: fmt.Sprintf("duplicate case %s in switch", lit), Pos: e.Position()})
+			}
+			seen[key] = true
+		}
+		if i == len(n.Cases)-1 && len(c.Do) > 0 {
+			if f, ok := c.Do[len(c.Do)-1].(*FallthroughStmt); ok {
+				t.addError(&Error{Message: "cannot fallthrough final case in switch", Pos: f.Position()})
+			}
+		}
+	}
+}
+
+// constKey 常量 case 按值比较, 整数和浮点数和运行时一样是不同的类型
+func constKey(e Expr) (key string, lit string, ok bool) {
+	sign := ""
+	if u, isUnary := e.(*UnaryExpr); isUnary && u.Operator == "-" {
+		sign, e = "-", u.Expr
+	}
+	switch e := e.(type) {
+	case *NumberExpr:
+		lit = sign + e.Lit
+		hex := strings.HasPrefix(e.Lit, "0x") || strings.HasPrefix(e.Lit, "0X")
+		if !hex && strings.ContainsAny(e.Lit, ".eE") {
+			f, err := strconv.ParseFloat(lit, 64)
+			return "float:" + strconv.FormatFloat(f, 'g', -1, 64), lit, err == nil
+		}
+		i, err := strconv.ParseInt(lit, 0, 64)
+		return "int:" + strconv.FormatInt(i, 10), lit, err == nil
+	case *StringExpr:
+		if sign != "" {
+			return "", "", false
+		}
+		return "string:" + e.Lit, strconv.Quote(e.Lit), true
+	case *ConstExpr:
+		if sign != "" {
+			return "", "", false
+		}
+		return e.Value, e.Value, true
+	}
+	return "", "", false
+}
+
+// ## import
+
+// parseImportStmt parse like
+//import "path/to/lib"
+func (t *Tree) parseImportStmt() Stmt {
+	n := t.newImportStmt()
+	t.match(IMPORT)
+
+	tok := t.match(STRING)
+	n.Path = tok.val
+	n.Name = strings.TrimSuffix(path.Base(tok.val), path.Ext(tok.val))
+	if !isIdentifier(n.Name) {
+		t.errorf(tok, "invalid module name %q in import path", n.Name)
+	}
+
+	if t.peek().typ == SEMICOLON {
+		t.match(SEMICOLON)
+	}
 	return n
 }
 
+// isIdentifier 模块名要能作为变量名使用
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isLetter(r) && (i == 0 || !isDigit(r)) {
+			return false
+		}
+	}
+	_, keyword := opName[name]
+	return !keyword
+}
+
 // ## 函数
 
 // parseFuncExpr
@@ -491,9 +1018,10 @@ func (t *Tree) parseBlock() []Stmt {
 
 	t.match(LC)
 
-	for t.peekNotNone(); t.peek().typ != RC; t.peekNotNone() {
-		statement := t.parseStmt()
-		n = append(n, statement)
+	for typ := t.peekNotNone().typ; typ != RC && typ != EOF; typ = t.peekNotNone().typ {
+		if statement := t.parseStmtOrSync(false); statement != nil {
+			n = append(n, statement)
+		}
 	}
 
 	t.match(RC)
@@ -508,142 +1036,200 @@ func (t *Tree) parseBlock() []Stmt {
 // parseExpr ...
 func (t *Tree) parseExpr() Expr {
 
-	// 函数
-	if t.peek().typ == FUNC {
-		expr := t.parseFuncExpr()
-		return expr
-	}
-
-	expr := t.parseLogicalOrExp()
+	expr := t.parseBinaryExp(1)
 
 	return expr
 }
 
-// or表达式
-func (t *Tree) parseLogicalOrExp() Expr {
-
-	expr := t.newBinOpExpr()
-	lExpr := t.parseLogicalAndExp()
-
-	if t.peek().typ == OROR {
-		expr.Lhs = lExpr
-		expr.Operator = t.peek().val
-
-		t.match(OROR)
-		expr.Rhs = t.parseLogicalOrExp()
-		return expr
-	}
-	return lExpr
+// binaryPrecedence 二元运算符的优先级, 数字大的先结合, 同一优先级都是左结合
+// 和 Go 一样, | 和加减, & 和乘除的优先级相同
+var binaryPrecedence = map[TokenType]int{
+	OROR:     1,
+	ANDAND:   2,
+	EQEQ:     3,
+	NEQ:      3,
+	GT:       4,
+	GE:       4,
+	LT:       4,
+	LE:       4,
+	IN:       4,
+	PLUS:     5,
+	MINUS:    5,
+	OR:       5,
+	MULTIPLY: 6,
+	DIVIDE:   6,
+	MOD:      6,
+	AND:      6,
 }
 
-// and 表达式
-func (t *Tree) parseLogicalAndExp() Expr {
+// 二元表达式, 只结合优先级不低于 prec 的运算符
+func (t *Tree) parseBinaryExp(prec int) Expr {
+	lExpr := t.parseUnaryExp()
 
-	expr := t.newBinOpExpr()
-	lExpr := t.parseEqualityExp()
+	for {
+		typ := t.peek().typ
+		p, ok := binaryPrecedence[typ]
+		if !ok || p < prec {
+			return lExpr
+		}
 
-	if t.peek().typ == ANDAND {
+		expr := t.newBinOpExpr()
+		expr.SetPosition(lExpr.Position())
 		expr.Lhs = lExpr
 		expr.Operator = t.peek().val
 
-		t.match(ANDAND)
-		expr.Rhs = t.parseLogicalAndExp()
-		return expr
+		t.match(typ)
+		// 右边只结合优先级更高的运算符, 所以是左结合
+		expr.Rhs = t.parseBinaryExp(p + 1)
+		lExpr = expr
 	}
-	return lExpr
 }
 
-// 相等表达式
-func (t *Tree) parseEqualityExp() Expr {
-	expr := t.newBinOpExpr()
-	lExpr := t.parseRelationalExp()
+// 一元表达式
+func (t *Tree) parseUnaryExp() Expr {
 
 	switch typ := t.peek().typ; typ {
-	case EQEQ, NEQ:
-		expr.Lhs = lExpr
+	case PLUS, MINUS, EXCLAMATION, XOR:
+		expr := t.newUnaryExpr()
 		expr.Operator = t.peek().val
 
 		t.match(typ)
-		expr.Rhs = t.parseEqualityExp()
+		expr.Expr = t.parseUnaryExp()
 		return expr
 	}
 
-	return lExpr
-}
+	expr := t.parsePostfixExp()
 
-// 关系表达式
-func (t *Tree) parseRelationalExp() Expr {
-	expr := t.newBinOpExpr()
+	return expr
 
-	lExpr := t.parseAdditiveExp()
+}
 
-	switch typ := t.peek().typ; typ {
-	case GT, GE, LT, LE:
-		expr.Lhs = lExpr
-		expr.Operator = t.peek().val
+// 后缀表达式
+func (t *Tree) parsePostfixExp() Expr {
+	expr := t.parsePrimaryExp()
 
-		t.match(typ)
-		expr.Rhs = t.parseRelationalExp()
-		return expr
+	for {
+		switch t.peek().typ {
+		case LB:
+			expr = t.parseIndexExp(expr)
+		case LP:
+			expr = t.parseCallExp(expr)
+		case DOT:
+			expr = t.parseMemberExp(expr)
+		default:
+			return expr
+		}
 	}
-
-	return lExpr
 }
 
-// 加法表达式
-func (t *Tree) parseAdditiveExp() Expr {
-	expr := t.newBinOpExpr()
-
-	lExpr := t.parseMultiplicativeExp()
-
-	switch typ := t.peek().typ; typ {
-	case PLUS, MINUS:
-		expr.Lhs = lExpr
-		expr.Operator = t.peek().val
+// parseCallExp parse like
+//f(1)(2)
+//func(a) {}(1)
+func (t *Tree) parseCallExp(fn Expr) Expr {
+	expr := t.newCallExpr()
+	// 位置是被调用的表达式开始的位置
+	expr.SetPosition(fn.Position())
+	expr.Func = fn
+	t.match(LP)
 
-		t.match(typ)
-		expr.Rhs = t.parseAdditiveExp()
-		return expr
+	if t.peek().typ != RP {
+		expr.SubExprs = t.parseExprList()
 	}
-
-	return lExpr
+	t.match(RP)
+	return expr
 }
 
-// 乘法表达式
-func (t *Tree) parseMultiplicativeExp() Expr {
-	expr := t.newBinOpExpr()
-
-	lExpr := t.parseUnaryExp()
+// parseMemberExp parse like
+//lib.name
+func (t *Tree) parseMemberExp(value Expr) Expr {
+	expr := t.newMemberExpr()
+	// 位置是 . 前面的表达式开始的位置
+	expr.SetPosition(value.Position())
+	t.match(DOT)
+	expr.Expr = value
+	expr.Name = t.match(IDENTI).val
+	return expr
+}
 
-	switch typ := t.peek().typ; typ {
-	case MULTIPLY, DIVIDE:
-		expr.Lhs = lExpr
-		expr.Operator = t.peek().val
+// parseIndexExp parse like
+//a[index]
+//a[begin:end]
+func (t *Tree) parseIndexExp(value Expr) Expr {
+	expr := t.newIndexExpr()
+	slice := t.newSliceExpr()
+	// 位置是被索引的表达式开始的位置
+	expr.SetPosition(value.Position())
+	slice.SetPosition(value.Position())
+	t.match(LB)
+
+	var begin Expr
+	if t.peek().typ != COLON {
+		begin = t.parseExpr()
+	}
 
-		t.match(typ)
-		expr.Rhs = t.parseMultiplicativeExp()
-		return expr
+	// 切片
+	if t.peek().typ == COLON {
+		t.match(COLON)
+		slice.Value = value
+		slice.Begin = begin
+		if t.peek().typ != RB {
+			slice.End = t.parseExpr()
+		}
+		t.match(RB)
+		return slice
 	}
 
-	return lExpr
+	t.match(RB)
+	expr.Value = value
+	expr.Index = begin
+	return expr
 }
 
-// 一元表达式
-func (t *Tree) parseUnaryExp() Expr {
+// parseMapExp parse like
+//{
+//    "key": value,
+//}
+func (t *Tree) parseMapExp() Expr {
+	expr := t.newMapExpr()
+	t.match(LC)
 
-	if t.peek().typ == PLUS {
-		expr := t.newUnaryExpr()
-		expr.Operator = t.peek().val
+	for t.peekNotNone().typ != RC {
+		expr.Keys = append(expr.Keys, t.parseExpr())
+		t.match(COLON)
+		t.peekNotNone()
+		expr.Values = append(expr.Values, t.parseExpr())
 
-		t.match(PLUS)
-		expr.Expr = t.parseUnaryExp()
-		return expr
+		if t.peekNotNone().typ != COMMA {
+			break
+		}
+		t.match(COMMA)
 	}
-
-	expr := t.parsePrimaryExp()
+	t.match(RC)
 
 	return expr
+}
 
+// parseInterpExp parse like
+//"a ${b} c ${d} e"
+func (t *Tree) parseInterpExp() Expr {
+	expr := t.newInterpExpr()
+	tok := t.match(STRBEGIN)
+
+	for {
+		expr.Lits = append(expr.Lits, tok.val)
+		expr.Raws = append(expr.Raws, tok.raw)
+		if tok.typ == STREND {
+			break
+		}
+		t.peekNotNone()
+		expr.Exprs = append(expr.Exprs, t.parseExpr())
+		if t.peekNotNone().typ == STRMID {
+			tok = t.match(STRMID)
+		} else {
+			tok = t.match(STREND)
+		}
+	}
+	return expr
 }
 
 // 表达式最小单元
@@ -671,6 +1257,10 @@ func (t *Tree) parsePrimaryExp() Expr {
 		t.match(RP)
 		return expr
 
+	case FUNC:
+		// 函数
+		return t.parseFuncExpr()
+
 	case LP:
 		// ()
 		expr := t.newParenExpr()
@@ -679,6 +1269,24 @@ func (t *Tree) parsePrimaryExp() Expr {
 		t.match(RP)
 		return expr
 
+	case LB:
+		// 数组
+		expr := t.newArrayExpr()
+		t.match(LB)
+		for t.peekNotNone().typ != RB {
+			expr.Exprs = append(expr.Exprs, t.parseExpr())
+			if t.peekNotNone().typ != COMMA {
+				break
+			}
+			t.match(COMMA)
+		}
+		t.match(RB)
+		return expr
+
+	case LC:
+		// 字典
+		return t.parseMapExp()
+
 	case NUMBER:
 		// number
 		expr := t.newNumberExpr()
@@ -686,13 +1294,17 @@ func (t *Tree) parsePrimaryExp() Expr {
 		return expr
 	case STRING:
 		expr := t.newStringExpr()
-		expr.Lit = t.match(STRING).val
+		tok := t.match(STRING)
+		expr.Lit, expr.Raw = tok.val, tok.raw
 		return expr
+	case STRBEGIN:
+		return t.parseInterpExp()
 	case BOOL, NIL:
 		expr := t.newConstExpr()
-		expr.Value = t.match(BOOL).val
+		expr.Value = t.next().val
 		return expr
 	default:
-		panic("fuck 不知道输入了什么鬼")
+		t.unexpected(t.peek(), "expression")
+		return nil
 	}
 }
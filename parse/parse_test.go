@@ -0,0 +1,19 @@
+package parse
+
+import "testing"
+
+// TestDebug 打印语法树时可以省略的部分为 nil
+func TestDebug(t *testing.T) {
+	Debug = true
+	defer func() { Debug = false }()
+	for _, src := range []string{
+		"for i = 0; i < 3; i = i + 1 { i; }",
+		"for i < 3 { break; }",
+		"for { break; }",
+		"func f() { return; }",
+	} {
+		if _, err := Parse(src); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
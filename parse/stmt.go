@@ -3,12 +3,25 @@ package parse
 // Stmt provides all of interfaces for statement.
 type Stmt interface {
 	Pos
+	End() Position
+	SetEnd(Position)
 	stmt()
 }
 
 // StmtImpl provide commonly implementations for Stmt..
 type StmtImpl struct {
 	PosImpl // StmtImpl provide Pos() function.
+	end     Position
+}
+
+// End 语句最后一个 token 的位置, 有语句块时是 } 的位置
+func (s *StmtImpl) End() Position {
+	return s.end
+}
+
+// SetEnd 设置语句最后一个 token 的位置
+func (s *StmtImpl) SetEnd(pos Position) {
+	s.end = pos
 }
 
 // stmt provide restraint interface.
@@ -56,12 +69,37 @@ type ForStmt struct {
 }
 func (s *ForStmt) stmt() {
 	print("## ForStmt: \n")
-	print("### Initial: \n")
-	s.Initial.expr()
-	print("### Condition: \n")
-	s.Condition.expr()
-	print("### After: \n")
-	s.After.expr()
+	// 只有条件或者死循环时子句为 nil
+	if s.Initial != nil {
+		print("### Initial: \n")
+		s.Initial.expr()
+	}
+	if s.Condition != nil {
+		print("### Condition: \n")
+		s.Condition.expr()
+	}
+	if s.After != nil {
+		print("### After: \n")
+		s.After.expr()
+	}
+	print("### Do: \n")
+	rangeStmt(s.Do)
+}
+
+// ForInStmt provide "for k, v in expr" statement.
+type ForInStmt struct {
+	StmtImpl
+	Vars []string // 一个或两个变量
+	Expr Expr
+	Do   []Stmt
+}
+
+func (s *ForInStmt) stmt() {
+	print("## ForInStmt: \n")
+	for _, v := range s.Vars {
+		print("### Var: ", v, "\n")
+	}
+	s.Expr.expr()
 	print("### Do: \n")
 	rangeStmt(s.Do)
 }
@@ -89,9 +127,91 @@ type ReturnStmt struct {
 }
 func (s *ReturnStmt) stmt() {
 	print("## ReturnStmt: \n")
+	if s.Expr != nil {
+		s.Expr.expr()
+	}
+}
+
+// TryStmt provide "try/catch/finally" statement.
+type TryStmt struct {
+	StmtImpl
+	Try     []Stmt
+	Var     string // catch 的变量名, 可以省略
+	Catch   []Stmt // 没有 catch 时为 nil
+	Finally []Stmt // 没有 finally 时为 nil
+}
+
+func (s *TryStmt) stmt() {
+	print("## TryStmt: \n")
+	print("### Try: \n")
+	rangeStmt(s.Try)
+	print("### Catch: ", s.Var, "\n")
+	rangeStmt(s.Catch)
+	print("### Finally: \n")
+	rangeStmt(s.Finally)
+}
+
+// ThrowStmt provide "throw" statement.
+type ThrowStmt struct {
+	StmtImpl
+	Expr Expr
+}
+
+func (s *ThrowStmt) stmt() {
+	print("## ThrowStmt: \n")
 	s.Expr.expr()
 }
 
+// SwitchStmt provide "switch" statement.
+type SwitchStmt struct {
+	StmtImpl
+	Expr  Expr
+	Cases []*CaseStmt
+}
+
+func (s *SwitchStmt) stmt() {
+	print("## SwitchStmt: \n")
+	s.Expr.expr()
+	for _, c := range s.Cases {
+		c.stmt()
+	}
+}
+
+// CaseStmt provide "case" and "default" clause of switch statement.
+type CaseStmt struct {
+	StmtImpl
+	Exprs []Expr // default 时为 nil
+	Do    []Stmt // 最后一条可以是 fallthrough
+}
+
+func (s *CaseStmt) stmt() {
+	print("### Case: \n")
+	rangeExpr(s.Exprs)
+	print("### Do: \n")
+	rangeStmt(s.Do)
+}
+
+// FallthroughStmt provide "fallthrough" statement.
+type FallthroughStmt struct {
+	StmtImpl
+}
+
+func (s *FallthroughStmt) stmt() {
+	print("## FallthroughStmt: \n")
+}
+
+// ImportStmt provide "import" statement. ex: import "lib/math"
+type ImportStmt struct {
+	StmtImpl
+	Path string
+	Name string // 模块名, 路径最后一部分去掉扩展名
+	File string // 导入模块的源文件, 相对路径从它所在的目录开始找
+}
+
+func (s *ImportStmt) stmt() {
+	print("## ImportStmt: ", s.Path, "\n")
+}
+
 // LetsStmt provide multiple statement of let.
 type LetsStmt struct {
 	StmtImpl
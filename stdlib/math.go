@@ -0,0 +1,108 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// 数学函数
+//////////////////////////////
+
+var mathFuncs = map[string]builtin{
+	"abs":   {builtinAbs, "abs(x) number\n绝对值, 整数的结果还是整数"},
+	"sqrt":  {mathFunc("sqrt", math.Sqrt), "sqrt(x) float\n平方根"},
+	"floor": {mathFunc("floor", math.Floor), "floor(x) float\n向下取整"},
+	"ceil":  {mathFunc("ceil", math.Ceil), "ceil(x) float\n向上取整"},
+	"pow":   {builtinPow, "pow(x, y) float\nx 的 y 次方"},
+	"min":   {minMax("min", -1), "min(x, y...) number\n最小值, 都是整数时结果是整数"},
+	"max":   {minMax("max", 1), "max(x, y...) number\n最大值, 都是整数时结果是整数"},
+}
+
+// number 取出数字, isInt 表示是不是整数
+func number(name string, v reflect.Value) (f float64, i int64, isInt bool, err error) {
+	v = elem(v)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), v.Int(), true, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), 0, false, nil
+	}
+	return 0, 0, false, fmt.Errorf("%s() argument must be number, not %s", name, typeOf(v))
+}
+
+// mathFunc 参数和结果都是浮点数的函数
+func mathFunc(name string, fn func(float64) float64) vm.Func {
+	return func(args ...reflect.Value) (reflect.Value, error) {
+		if err := checkArgs(name, args, 1); err != nil {
+			return vm.NilValue, err
+		}
+		x, _, _, err := number(name, args[0])
+		if err != nil {
+			return vm.NilValue, err
+		}
+		return reflect.ValueOf(fn(x)), nil
+	}
+}
+
+func builtinAbs(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("abs", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	f, i, isInt, err := number("abs", args[0])
+	if err != nil {
+		return vm.NilValue, err
+	}
+	if isInt {
+		if i < 0 {
+			i = -i
+		}
+		return reflect.ValueOf(i), nil
+	}
+	return reflect.ValueOf(math.Abs(f)), nil
+}
+
+func builtinPow(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("pow", args, 2); err != nil {
+		return vm.NilValue, err
+	}
+	x, _, _, err := number("pow", args[0])
+	if err != nil {
+		return vm.NilValue, err
+	}
+	y, _, _, err := number("pow", args[1])
+	if err != nil {
+		return vm.NilValue, err
+	}
+	return reflect.ValueOf(math.Pow(x, y)), nil
+}
+
+// minMax sign 为 -1 时取最小值, 为 1 时取最大值
+func minMax(name string, sign int) vm.Func {
+	return func(args ...reflect.Value) (reflect.Value, error) {
+		if len(args) < 1 {
+			return vm.NilValue, errors.New(name + "() takes at least one argument")
+		}
+		var best reflect.Value
+		var bestF float64
+		allInt := true
+		for _, arg := range args {
+			f, _, isInt, err := number(name, arg)
+			if err != nil {
+				return vm.NilValue, err
+			}
+			allInt = allInt && isInt
+			if !best.IsValid() || sign < 0 && f < bestF || sign > 0 && f > bestF {
+				best, bestF = elem(arg), f
+			}
+		}
+		if allInt {
+			return reflect.ValueOf(best.Int()), nil
+		}
+		return reflect.ValueOf(bestF), nil
+	}
+}
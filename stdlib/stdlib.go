@@ -0,0 +1,354 @@
+// Package stdlib 脚本的标准库, 用 Import 定义到需要的环境中
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/lth-go/gogogo/vm"
+)
+
+// builtin 标准库函数, 签名的第二行起是说明
+type builtin struct {
+	fn  vm.Func
+	sig string
+}
+
+// packages 所有的标准库函数
+var packages = []map[string]builtin{builtinFuncs, coreFuncs, mathFuncs, stringFuncs}
+
+// DefineBuiltins 定义不用导入标准库也有的 len, delete, keys
+func DefineBuiltins(env *vm.Env) {
+	for name, b := range builtinFuncs {
+		env.Define(name, b.fn)
+	}
+}
+
+// Import 把标准库函数定义到环境中
+func Import(env *vm.Env) {
+	for _, funcs := range packages {
+		for name, b := range funcs {
+			env.Define(name, b.fn)
+		}
+	}
+}
+
+// Signature 标准库函数的签名和说明
+func Signature(name string) (string, bool) {
+	for _, funcs := range packages {
+		if b, ok := funcs[name]; ok {
+			return b.sig, true
+		}
+	}
+	return "", false
+}
+
+//////////////////////////////
+// 参数
+//////////////////////////////
+
+var numberNames = []string{"no", "one", "two", "three"}
+
+// checkArgs 检查参数个数
+func checkArgs(name string, args []reflect.Value, n int) error {
+	if len(args) == n {
+		return nil
+	}
+	if n == 1 {
+		return fmt.Errorf("%s() takes exactly one argument", name)
+	}
+	return fmt.Errorf("%s() takes exactly %s arguments", name, numberNames[n])
+}
+
+// elem 取出 interface 中的值
+func elem(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		return v.Elem()
+	}
+	return v
+}
+
+// typeOf 脚本中的类型名
+func typeOf(v reflect.Value) string {
+	v = elem(v)
+	if !v.IsValid() || v == vm.NilValue {
+		return "nil"
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Array, reflect.Slice:
+		return "array"
+	case reflect.Map:
+		return "map"
+	case reflect.Func:
+		return "func"
+	}
+	if _, ok := v.Interface().(*vm.Env); ok {
+		return "package"
+	}
+	if _, ok := v.Interface().(reflect.Type); ok {
+		return "type"
+	}
+	return v.Type().String()
+}
+
+// toInterfaces 转换为 fmt 可以使用的参数
+func toInterfaces(args []reflect.Value) []interface{} {
+	a := make([]interface{}, len(args))
+	for i, arg := range args {
+		arg = elem(arg)
+		if arg.IsValid() && arg != vm.NilValue && arg.CanInterface() {
+			a[i] = arg.Interface()
+		}
+	}
+	return a
+}
+
+//////////////////////////////
+// 基本函数
+//////////////////////////////
+
+// builtinFuncs 解释器总是定义的函数
+var builtinFuncs = map[string]builtin{
+	"len":    {builtinLen, "len(v) int\n数组, 字典的元素个数, 字符串按字符计算"},
+	"keys":   {builtinKeys, "keys(m) array\n字典的键, 按固定的顺序排列"},
+	"delete": {builtinDelete, "delete(m, key)\n删除字典中的键"},
+}
+
+var coreFuncs = map[string]builtin{
+	"str":     {builtinStr, "str(v) string\n转换为字符串, 和 print 的输出相同"},
+	"int":     {builtinInt, "int(v) int\n把数字或字符串转换为整数, 浮点数向零取整"},
+	"float":   {builtinFloat, "float(v) float\n把数字或字符串转换为浮点数"},
+	"type":    {builtinType, "type(v) string\n值的类型: nil, bool, int, float, string, array, map, func, package, type"},
+	"range":   {builtinRange, "range([start, ]stop[, step]) array\n从 start 到 stop 之前的整数, 默认从 0 开始"},
+	"append":  {builtinAppend, "append(arr, values...) array\n返回在数组后面加上 values 的新数组"},
+	"sprintf": {builtinSprintf, "sprintf(format, args...) string\n按 Go 的 fmt.Sprintf 格式化"},
+	"sort":    {builtinSort, "sort(arr[, less]) array\n排序数组并返回, less(a, b) 为真时 a 排在前面, 默认和字典键的顺序相同"},
+}
+
+// builtinLen 数组, 字符串的长度, 字符串按字符计算
+func builtinLen(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("len", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	v := elem(args[0])
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.Chan:
+		return reflect.ValueOf(int64(v.Len())), nil
+	case reflect.String:
+		return reflect.ValueOf(int64(utf8.RuneCountInString(v.String()))), nil
+	}
+	return vm.NilValue, fmt.Errorf("invalid argument %s for len()", typeOf(v))
+}
+
+func builtinStr(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("str", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	return reflect.ValueOf(vm.ToString(args[0])), nil
+}
+
+func builtinInt(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("int", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	v := elem(args[0])
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.ValueOf(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.ValueOf(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return reflect.ValueOf(int64(v.Float())), nil
+	case reflect.String:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return vm.NilValue, fmt.Errorf("cannot convert %q to int", v.String())
+		}
+		return reflect.ValueOf(i), nil
+	}
+	return vm.NilValue, fmt.Errorf("cannot convert %s to int", typeOf(v))
+}
+
+func builtinFloat(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("float", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	v := elem(args[0])
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.ValueOf(float64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.ValueOf(float64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return reflect.ValueOf(v.Float()), nil
+	case reflect.String:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return vm.NilValue, fmt.Errorf("cannot convert %q to float", v.String())
+		}
+		return reflect.ValueOf(f), nil
+	}
+	return vm.NilValue, fmt.Errorf("cannot convert %s to float", typeOf(v))
+}
+
+func builtinType(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("type", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	return reflect.ValueOf(typeOf(args[0])), nil
+}
+
+func builtinRange(args ...reflect.Value) (reflect.Value, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return vm.NilValue, errors.New("range() takes one to three arguments")
+	}
+	n := make([]int64, len(args))
+	for i, arg := range args {
+		arg = elem(arg)
+		switch arg.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n[i] = arg.Int()
+		default:
+			return vm.NilValue, fmt.Errorf("range() arguments must be int, not %s", typeOf(arg))
+		}
+	}
+	start, stop, step := int64(0), n[0], int64(1)
+	if len(n) > 1 {
+		start, stop = n[0], n[1]
+	}
+	if len(n) > 2 {
+		step = n[2]
+	}
+	if step == 0 {
+		return vm.NilValue, errors.New("range() step must not be zero")
+	}
+	a := []interface{}{}
+	for i := start; step > 0 && i < stop || step < 0 && i > stop; i += step {
+		a = append(a, i)
+	}
+	return reflect.ValueOf(a), nil
+}
+
+func builtinAppend(args ...reflect.Value) (reflect.Value, error) {
+	if len(args) < 1 {
+		return vm.NilValue, errors.New("append() takes at least one argument")
+	}
+	a := []interface{}{}
+	v := elem(args[0])
+	switch {
+	case !v.IsValid() || v == vm.NilValue:
+	case v.Kind() == reflect.Array || v.Kind() == reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			a = append(a, v.Index(i).Interface())
+		}
+	default:
+		return vm.NilValue, fmt.Errorf("first argument to append() must be array, not %s", typeOf(v))
+	}
+	a = append(a, toInterfaces(args[1:])...)
+	return reflect.ValueOf(a), nil
+}
+
+// builtinKeys 字典的键, 按 vm.SortedKeys 的顺序排列
+func builtinKeys(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("keys", args, 1); err != nil {
+		return vm.NilValue, err
+	}
+	m := elem(args[0])
+	if m.Kind() != reflect.Map {
+		return vm.NilValue, fmt.Errorf("argument to keys() must be map, not %s", typeOf(m))
+	}
+	keys := []interface{}{}
+	for _, k := range vm.SortedKeys(m) {
+		keys = append(keys, k.Interface())
+	}
+	return reflect.ValueOf(keys), nil
+}
+
+// builtinDelete 删除字典中的键
+func builtinDelete(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("delete", args, 2); err != nil {
+		return vm.NilValue, err
+	}
+	m := elem(args[0])
+	if m.Kind() != reflect.Map {
+		return vm.NilValue, fmt.Errorf("first argument to delete() must be map, not %s", typeOf(m))
+	}
+	k, err := vm.ConvertTo(args[1], m.Type().Key())
+	if err != nil {
+		return vm.NilValue, fmt.Errorf("invalid key for delete(): %v", err)
+	}
+	m.SetMapIndex(k, reflect.Value{})
+	return vm.NilValue, nil
+}
+
+func builtinSprintf(args ...reflect.Value) (reflect.Value, error) {
+	if len(args) < 1 {
+		return vm.NilValue, errors.New("sprintf() takes at least one argument")
+	}
+	format := elem(args[0])
+	if format.Kind() != reflect.String {
+		return vm.NilValue, fmt.Errorf("format of sprintf() must be string, not %s", typeOf(format))
+	}
+	return reflect.ValueOf(fmt.Sprintf(format.String(), toInterfaces(args[1:])...)), nil
+}
+
+// builtinSort 原地排序, less 出错时停止排序并返回错误
+func builtinSort(args ...reflect.Value) (reflect.Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return vm.NilValue, errors.New("sort() takes one or two arguments")
+	}
+	a := elem(args[0])
+	if a.Kind() != reflect.Slice {
+		return vm.NilValue, fmt.Errorf("first argument to sort() must be array, not %s", typeOf(a))
+	}
+
+	var err error
+	less := func(x, y reflect.Value) bool {
+		return vm.Less(x, y)
+	}
+	if len(args) == 2 {
+		f := args[1]
+		less = func(x, y reflect.Value) bool {
+			if err != nil {
+				return false
+			}
+			var rv reflect.Value
+			rv, err = vm.Call(f, x, y)
+			rv = elem(rv)
+			return err == nil && rv.Kind() == reflect.Bool && rv.Bool()
+		}
+	}
+
+	// 排序取出来的值, 最后写回数组
+	values := make([]reflect.Value, a.Len())
+	for i := range values {
+		values[i] = elem(a.Index(i))
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return less(values[i], values[j])
+	})
+	if err != nil {
+		return vm.NilValue, err
+	}
+	for i, v := range values {
+		if v.IsValid() {
+			a.Index(i).Set(v)
+		} else {
+			a.Index(i).Set(reflect.Zero(a.Type().Elem()))
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,82 @@
+package stdlib
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lth-go/gogogo/vm"
+)
+
+//////////////////////////////
+// 字符串函数
+//////////////////////////////
+
+var stringFuncs = map[string]builtin{
+	"split":    {builtinSplit, "split(s, sep) array\n用 sep 切分字符串"},
+	"join":     {builtinJoin, "join(arr, sep) string\n用 sep 连接数组的元素, 元素按 str 转换"},
+	"replace":  {builtinReplace, "replace(s, old, new) string\n替换所有的 old"},
+	"contains": {builtinContains, "contains(s, sub) bool\n字符串是否包含 sub"},
+}
+
+// strArgs 检查参数个数并取出字符串参数
+func strArgs(name string, args []reflect.Value, n int) ([]string, error) {
+	if err := checkArgs(name, args, n); err != nil {
+		return nil, err
+	}
+	s := make([]string, n)
+	for i, arg := range args {
+		arg = elem(arg)
+		if arg.Kind() != reflect.String {
+			return nil, fmt.Errorf("%s() argument %d must be string, not %s", name, i+1, typeOf(arg))
+		}
+		s[i] = arg.String()
+	}
+	return s, nil
+}
+
+func builtinSplit(args ...reflect.Value) (reflect.Value, error) {
+	s, err := strArgs("split", args, 2)
+	if err != nil {
+		return vm.NilValue, err
+	}
+	a := []interface{}{}
+	for _, part := range strings.Split(s[0], s[1]) {
+		a = append(a, part)
+	}
+	return reflect.ValueOf(a), nil
+}
+
+func builtinJoin(args ...reflect.Value) (reflect.Value, error) {
+	if err := checkArgs("join", args, 2); err != nil {
+		return vm.NilValue, err
+	}
+	a, sep := elem(args[0]), elem(args[1])
+	if a.Kind() != reflect.Array && a.Kind() != reflect.Slice {
+		return vm.NilValue, fmt.Errorf("join() argument 1 must be array, not %s", typeOf(a))
+	}
+	if sep.Kind() != reflect.String {
+		return vm.NilValue, fmt.Errorf("join() argument 2 must be string, not %s", typeOf(sep))
+	}
+	parts := make([]string, a.Len())
+	for i := range parts {
+		parts[i] = vm.ToString(a.Index(i))
+	}
+	return reflect.ValueOf(strings.Join(parts, sep.String())), nil
+}
+
+func builtinReplace(args ...reflect.Value) (reflect.Value, error) {
+	s, err := strArgs("replace", args, 3)
+	if err != nil {
+		return vm.NilValue, err
+	}
+	return reflect.ValueOf(strings.ReplaceAll(s[0], s[1], s[2])), nil
+}
+
+func builtinContains(args ...reflect.Value) (reflect.Value, error) {
+	s, err := strArgs("contains", args, 2)
+	if err != nil {
+		return vm.NilValue, err
+	}
+	return reflect.ValueOf(strings.Contains(s[0], s[1])), nil
+}
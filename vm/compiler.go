@@ -0,0 +1,1018 @@
+package vm
+
+import (
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 字节码编译器
+//////////////////////////////
+
+// variable 作用域中的局部变量
+type variable struct {
+	slot int
+	// 赋值产生的变量, 未定义时和树遍历一样先找全局变量
+	hybrid bool
+}
+
+// blockFrame 运行时的 frame, 函数和需要单独 frame 的语句块各有一个
+type blockFrame struct {
+	numSlots int
+	enter    int // OpEnterBlock 指令, 回填 frame 大小
+}
+
+type scope struct {
+	vars   map[string]variable
+	parent *scope
+	frame  *blockFrame // 变量所在的 frame
+	owns   bool        // frame 是否属于这个作用域
+}
+
+type loop struct {
+	breaks    []int
+	continues []int
+	blocks    int // 循环体外已经进入的语句块 frame 数
+}
+
+// tryBlock 正在编译的 try 或 catch, 跳出时先离开它并执行 finally
+type tryBlock struct {
+	finally []parse.Stmt // 没有 finally 时为 nil
+	scope   *scope       // try 语句所在的作用域
+	blocks  int          // try 语句外已经进入的语句块 frame 数
+	loops   int          // try 语句外的循环数
+}
+
+type funcState struct {
+	proto  *Proto
+	parent *funcState
+	base   *blockFrame // 函数的 frame
+	scope  *scope      // 顶层代码为 nil, 表示全局作用域
+	blocks int         // 已经进入的语句块 frame 数
+	loops  []*loop
+	tries  []*tryBlock
+	consts map[interface{}]int
+	// 正在编译循环体或 finally, 语句的值用不到, finally 也不能改变 try 语句的值
+	discard bool
+}
+
+type compiler struct {
+	fs *funcState
+}
+
+// Compile 把语法树编译成字节码
+func Compile(stmts []parse.Stmt) (*Proto, error) {
+	c := &compiler{}
+	c.openFunc(&Proto{Name: "main"}, true)
+
+	if err := c.stmts(stmts); err != nil {
+		return nil, err
+	}
+	c.emit(nil, OpReturnLast, 0, 0, 0)
+
+	return c.closeFunc(), nil
+}
+
+//////////////////////////////
+// 函数, 作用域
+//////////////////////////////
+
+// openFunc 开始编译函数, 顶层代码没有作用域
+func (c *compiler) openFunc(p *Proto, toplevel bool) {
+	fs := &funcState{
+		proto:  p,
+		parent: c.fs,
+		base:   &blockFrame{},
+		consts: make(map[interface{}]int),
+	}
+	if !toplevel {
+		// 形参和函数体共用一个环境
+		fs.scope = &scope{vars: make(map[string]variable), frame: fs.base, owns: true}
+	}
+	c.fs = fs
+}
+
+func (c *compiler) closeFunc() *Proto {
+	p := c.fs.proto
+	p.NumSlots = c.fs.base.numSlots
+	c.fs = c.fs.parent
+	return p
+}
+
+func (c *compiler) frame() *blockFrame {
+	if c.fs.scope == nil {
+		return c.fs.base
+	}
+	return c.fs.scope.frame
+}
+
+// openBlock 打开语句块的作用域, 用 declare 预先声明变量.
+// 块中定义了变量又有函数定义时, 闭包可能在块结束后还引用这些变量,
+// 所以每次进入块时新建 frame, 否则变量放在外层 frame 中, 进入时清除.
+func (c *compiler) openBlock(pos parse.Pos, closure bool, declare func()) {
+	parent := c.frame()
+	s := &scope{
+		vars:   make(map[string]variable),
+		parent: c.fs.scope,
+		frame:  &blockFrame{},
+		owns:   true,
+	}
+	c.fs.scope = s
+	declare()
+
+	if closure && len(s.vars) > 0 {
+		s.frame.enter = c.emit(pos, OpEnterBlock, 0, 0, 0)
+		c.fs.blocks++
+		return
+	}
+
+	first := parent.numSlots
+	for name, v := range s.vars {
+		v.slot += first
+		s.vars[name] = v
+	}
+	parent.numSlots += s.frame.numSlots
+	s.frame = parent
+	s.owns = false
+	if last := parent.numSlots; last > first {
+		c.emit(pos, OpClear, first, last, 0)
+	}
+}
+
+func (c *compiler) closeBlock(pos parse.Pos) {
+	s := c.fs.scope
+	if s.owns {
+		c.fs.proto.Code[s.frame.enter].A = s.frame.numSlots
+		c.emit(pos, OpLeaveBlock, 1, 0, 0)
+		c.fs.blocks--
+	}
+	c.fs.scope = s.parent
+}
+
+// newSlot 在当前作用域分配局部变量
+func (c *compiler) newSlot(name string, hybrid bool) variable {
+	f := c.fs.scope.frame
+	v := variable{slot: f.numSlots, hybrid: hybrid}
+	f.numSlots++
+	c.fs.scope.vars[name] = v
+	return v
+}
+
+// resolve 查找变量, depth 为相对当前 frame 的层数
+func (c *compiler) resolve(name string) (v variable, depth int, ok bool) {
+	for fs := c.fs; fs != nil; fs = fs.parent {
+		for s := fs.scope; s != nil; s = s.parent {
+			if v, ok := s.vars[name]; ok {
+				return v, depth, true
+			}
+			if s.owns {
+				depth++
+			}
+		}
+	}
+	return variable{}, 0, false
+}
+
+// hasFuncExpr 判断语法树中是否有函数定义
+func hasFuncExpr(node interface{}) bool {
+	return walkFuncExpr(reflect.ValueOf(node))
+}
+
+func walkFuncExpr(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return false
+		}
+		if _, ok := v.Interface().(*parse.FuncExpr); ok {
+			return true
+		}
+		return walkFuncExpr(v.Elem())
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if walkFuncExpr(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" && walkFuncExpr(v.Field(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declare 预先声明语句块中赋值的变量, 和树遍历的 Env 对应
+func (c *compiler) declare(stmts []parse.Stmt) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *parse.LetsStmt:
+			c.declareLhss(stmt.Lhss)
+		case *parse.ExprStmt:
+			c.declareExpr(stmt.Expr)
+		case *parse.IfStmt:
+			// elif 在外层环境中执行
+			for _, elif := range stmt.Elif {
+				c.declare(elif.(*parse.IfStmt).Do)
+			}
+		}
+	}
+}
+
+func (c *compiler) declareExpr(expr parse.Expr) {
+	switch e := expr.(type) {
+	case *parse.FuncExpr:
+		if e.Name != "" {
+			c.declareName(e.Name)
+		}
+	case *parse.LetsExpr:
+		c.declareLhss(e.Lhss)
+	}
+}
+
+func (c *compiler) declareLhss(lhss []parse.Expr) {
+	for _, lhs := range lhss {
+		if ident, ok := lhs.(*parse.IdentExpr); ok {
+			c.declareName(ident.Lit)
+		}
+	}
+}
+
+func (c *compiler) declareName(name string) {
+	if c.fs.scope == nil {
+		return
+	}
+	if _, _, ok := c.resolve(name); ok {
+		return
+	}
+	c.newSlot(name, true)
+}
+
+//////////////////////////////
+// 指令
+//////////////////////////////
+
+func (c *compiler) emit(pos parse.Pos, op Opcode, a, b, cc int) int {
+	p := c.fs.proto
+	position := parse.Position{Line: 1, Column: 1}
+	if pos != nil {
+		position = pos.Position()
+	} else if n := len(p.Pos); n > 0 {
+		position = p.Pos[n-1]
+	}
+	p.Code = append(p.Code, Instr{Op: op, A: a, B: b, C: cc})
+	p.Pos = append(p.Pos, position)
+	return len(p.Code) - 1
+}
+
+// patch 回填跳转地址
+func (c *compiler) patch(pc int) {
+	c.fs.proto.Code[pc].A = len(c.fs.proto.Code)
+}
+
+func (c *compiler) constant(v interface{}) int {
+	if i, ok := c.fs.consts[v]; ok {
+		return i
+	}
+	p := c.fs.proto
+	p.Consts = append(p.Consts, reflect.ValueOf(v))
+	c.fs.consts[v] = len(p.Consts) - 1
+	return len(p.Consts) - 1
+}
+
+func (c *compiler) load(pos parse.Pos, name string) {
+	v, depth, ok := c.resolve(name)
+	switch {
+	case !ok:
+		c.emit(pos, OpLoadGlobal, c.constant(name), 0, 0)
+	case v.hybrid:
+		c.emit(pos, OpLoadName, v.slot, depth, c.constant(name))
+	default:
+		c.emit(pos, OpLoadLocal, v.slot, depth, 0)
+	}
+}
+
+func (c *compiler) store(pos parse.Pos, name string) {
+	v, depth, ok := c.resolve(name)
+	if !ok {
+		if c.fs.scope == nil {
+			c.emit(pos, OpStoreGlobal, c.constant(name), 0, 0)
+			return
+		}
+		v = c.newSlot(name, true)
+	}
+	c.emit(pos, OpStoreName, v.slot, depth, c.constant(name))
+}
+
+// define 在当前环境中定义变量
+func (c *compiler) define(pos parse.Pos, name string) {
+	if c.fs.scope == nil {
+		c.emit(pos, OpDefineGlobal, c.constant(name), 0, 0)
+		return
+	}
+	v, ok := c.fs.scope.vars[name]
+	if !ok {
+		v = c.newSlot(name, true)
+	}
+	c.emit(pos, OpStoreLocal, v.slot, 0, 0)
+}
+
+//////////////////////////////
+// stmt
+//////////////////////////////
+
+// stmts 语句块的值是最后一条语句的值, 和树遍历一样,
+// 表达式语句和 if, switch, try 执行的语句块有值, 其它语句的值为 nil
+func (c *compiler) stmts(stmts []parse.Stmt) error {
+	for _, stmt := range stmts {
+		if err := c.stmt(stmt); err != nil {
+			return err
+		}
+	}
+	if len(stmts) == 0 {
+		c.clearLast(nil)
+		return nil
+	}
+	switch stmt := stmts[len(stmts)-1].(type) {
+	case *parse.ExprStmt, *parse.IfStmt, *parse.SwitchStmt, *parse.TryStmt:
+		// 自己设置最后的值
+	case *parse.BreakStmt, *parse.ContinueStmt, *parse.ReturnStmt, *parse.ThrowStmt:
+		// 跳走了, 后面的指令不会执行
+	default:
+		c.clearLast(stmt)
+	}
+	return nil
+}
+
+// clearLast 把最后的值设为 nil
+func (c *compiler) clearLast(pos parse.Pos) {
+	if c.fs.discard {
+		return
+	}
+	c.emit(pos, OpNil, 0, 0, 0)
+	c.emit(pos, OpPop, 0, 0, 0)
+}
+
+// discard 编译值用不到的语句
+func (c *compiler) discard(f func() error) error {
+	discard := c.fs.discard
+	c.fs.discard = true
+	err := f()
+	c.fs.discard = discard
+	return err
+}
+
+// block 在新的作用域中编译语句块
+func (c *compiler) block(pos parse.Pos, stmts []parse.Stmt) error {
+	c.openBlock(pos, hasFuncExpr(stmts), func() {
+		c.declare(stmts)
+	})
+	if err := c.stmts(stmts); err != nil {
+		return err
+	}
+	c.closeBlock(pos)
+	return nil
+}
+
+// jumpLoop 跳出循环前离开循环体内进入的 frame
+func (c *compiler) jumpLoop(pos parse.Pos, l *loop) int {
+	if n := c.fs.blocks - l.blocks; n > 0 {
+		c.emit(pos, OpLeaveBlock, n, 0, 0)
+	}
+	return c.emit(pos, OpJump, 0, 0, 0)
+}
+
+func (c *compiler) stmt(stmt parse.Stmt) error {
+	switch stmt := stmt.(type) {
+	case *parse.ExprStmt:
+		if err := c.expr(stmt.Expr); err != nil {
+			return err
+		}
+		if c.fs.discard {
+			c.emit(stmt, OpDrop, 0, 0, 0)
+		} else {
+			c.emit(stmt, OpPop, 0, 0, 0)
+		}
+	case *parse.LetsStmt:
+		return c.lets(stmt, stmt.Lhss, stmt.Rhss)
+	case *parse.IfStmt:
+		return c.ifStmt(stmt)
+	case *parse.ForStmt:
+		return c.forStmt(stmt)
+	case *parse.ForInStmt:
+		return c.forInStmt(stmt)
+	case *parse.SwitchStmt:
+		return c.switchStmt(stmt)
+	case *parse.FallthroughStmt:
+		// 由 switchStmt 处理
+	case *parse.BreakStmt:
+		if len(c.fs.loops) == 0 {
+			return NewStringError(stmt, BreakError.Error())
+		}
+		l := c.fs.loops[len(c.fs.loops)-1]
+		restore, err := c.leaveTries(stmt, c.loopTries())
+		if err != nil {
+			return err
+		}
+		l.breaks = append(l.breaks, c.jumpLoop(stmt, l))
+		restore()
+	case *parse.ContinueStmt:
+		if len(c.fs.loops) == 0 {
+			return NewStringError(stmt, ContinueError.Error())
+		}
+		l := c.fs.loops[len(c.fs.loops)-1]
+		restore, err := c.leaveTries(stmt, c.loopTries())
+		if err != nil {
+			return err
+		}
+		l.continues = append(l.continues, c.jumpLoop(stmt, l))
+		restore()
+	case *parse.ImportStmt:
+		p := c.fs.proto
+		p.Imports = append(p.Imports, stmt)
+		c.emit(stmt, OpImport, len(p.Imports)-1, 0, 0)
+		c.define(stmt, stmt.Name)
+	case *parse.ReturnStmt:
+		if c.fs.parent == nil {
+			return NewStringError(stmt, ReturnError.Error())
+		}
+		if stmt.Expr == nil {
+			c.emit(stmt, OpNil, 0, 0, 0)
+		} else if err := c.expr(stmt.Expr); err != nil {
+			return err
+		}
+		restore, err := c.leaveTries(stmt, 0)
+		if err != nil {
+			return err
+		}
+		c.emit(stmt, OpReturn, 0, 0, 0)
+		restore()
+	case *parse.TryStmt:
+		return c.tryStmt(stmt)
+	case *parse.ThrowStmt:
+		if err := c.expr(stmt.Expr); err != nil {
+			return err
+		}
+		c.emit(stmt, OpThrow, 0, 0, 0)
+	default:
+		return NewStringError(stmt, "unknown statement")
+	}
+	return nil
+}
+
+// lets 多重赋值, 先求出所有右值再依次赋值
+func (c *compiler) lets(pos parse.Pos, lhss []parse.Expr, rhss []parse.Expr) error {
+	for _, rhs := range rhss {
+		if err := c.expr(rhs); err != nil {
+			return err
+		}
+	}
+	n := len(lhss)
+	if len(rhss) < n {
+		n = len(rhss)
+	}
+	for i := n; i < len(rhss); i++ {
+		c.emit(pos, OpDrop, 0, 0, 0)
+	}
+	if n > 1 {
+		c.emit(pos, OpReverse, n, 0, 0)
+	}
+	for _, lhs := range lhss[:n] {
+		if err := c.assign(lhs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assign 把栈顶的值赋给左值
+func (c *compiler) assign(lhs parse.Expr) error {
+	switch lhs := lhs.(type) {
+	case *parse.IdentExpr:
+		c.store(lhs, lhs.Lit)
+	case *parse.IndexExpr:
+		if err := c.expr(lhs.Value); err != nil {
+			return err
+		}
+		if err := c.expr(lhs.Index); err != nil {
+			return err
+		}
+		c.emit(lhs, OpSetIndex, 0, 0, 0)
+	case *parse.MemberExpr:
+		if err := c.expr(lhs.Expr); err != nil {
+			return err
+		}
+		c.emit(lhs, OpSetMember, c.constant(lhs.Name), 0, 0)
+	default:
+		return NewStringError(lhs, "Invalid operation")
+	}
+	return nil
+}
+
+func (c *compiler) ifStmt(stmt *parse.IfStmt) error {
+	var ends []int
+
+	if err := c.expr(stmt.Condition); err != nil {
+		return err
+	}
+	next := c.emit(stmt, OpJumpIfFalse, 0, 0, 0)
+	if err := c.block(stmt, stmt.Do); err != nil {
+		return err
+	}
+	ends = append(ends, c.emit(stmt, OpJump, 0, 0, 0))
+
+	for _, elif := range stmt.Elif {
+		elif := elif.(*parse.IfStmt)
+		c.patch(next)
+		if err := c.expr(elif.Condition); err != nil {
+			return err
+		}
+		next = c.emit(elif, OpJumpIfFalse, 0, 0, 0)
+		// elif 在外层环境中执行
+		if err := c.stmts(elif.Do); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(elif, OpJump, 0, 0, 0))
+	}
+
+	c.patch(next)
+	if len(stmt.Else) > 0 {
+		if err := c.block(stmt, stmt.Else); err != nil {
+			return err
+		}
+	} else {
+		// 没有执行的语句块
+		c.clearLast(stmt)
+	}
+	for _, pc := range ends {
+		c.patch(pc)
+	}
+	return nil
+}
+
+func (c *compiler) forStmt(stmt *parse.ForStmt) error {
+	// 初始化, 条件, 循环体共用一个环境
+	c.openBlock(stmt, hasFuncExpr(stmt), func() {
+		if stmt.Initial != nil {
+			c.declareExpr(stmt.Initial)
+		}
+		if stmt.After != nil {
+			c.declareExpr(stmt.After)
+		}
+		c.declare(stmt.Do)
+	})
+
+	if stmt.Initial != nil {
+		if err := c.expr(stmt.Initial); err != nil {
+			return err
+		}
+		c.emit(stmt, OpDrop, 0, 0, 0)
+	}
+
+	start := len(c.fs.proto.Code)
+	exit := -1
+	if stmt.Condition != nil {
+		if err := c.expr(stmt.Condition); err != nil {
+			return err
+		}
+		exit = c.emit(stmt, OpJumpIfFalse, 0, 0, 0)
+	}
+
+	l := &loop{blocks: c.fs.blocks}
+	c.fs.loops = append(c.fs.loops, l)
+	err := c.discard(func() error {
+		return c.stmts(stmt.Do)
+	})
+	c.fs.loops = c.fs.loops[:len(c.fs.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	for _, pc := range l.continues {
+		c.patch(pc)
+	}
+	if stmt.After != nil {
+		if err := c.expr(stmt.After); err != nil {
+			return err
+		}
+		c.emit(stmt, OpDrop, 0, 0, 0)
+	}
+	c.emit(stmt, OpJump, start, 0, 0)
+
+	if exit >= 0 {
+		c.patch(exit)
+	}
+	for _, pc := range l.breaks {
+		c.patch(pc)
+	}
+	c.closeBlock(stmt)
+	return nil
+}
+
+// forInStmt 遍历的值在进入循环的环境前求值, 迭代器放在没有名字的局部变量中
+//
+//	expr; ITER n; STORE it
+//	start: LOAD it; NEXT end n; STORE 变量; 循环体; JUMP start
+//	end:
+func (c *compiler) forInStmt(stmt *parse.ForInStmt) error {
+	if err := c.expr(stmt.Expr); err != nil {
+		return err
+	}
+	c.emit(stmt.Expr, OpIter, len(stmt.Vars), 0, 0)
+
+	c.openBlock(stmt, hasFuncExpr(stmt.Do), func() {
+		c.newSlot("", false)
+		for _, name := range stmt.Vars {
+			c.newSlot(name, false)
+		}
+		c.declare(stmt.Do)
+	})
+	it := c.fs.scope.vars[""]
+	c.emit(stmt, OpStoreLocal, it.slot, 0, 0)
+
+	start := c.emit(stmt, OpLoadLocal, it.slot, 0, 0)
+	exit := c.emit(stmt, OpNext, 0, len(stmt.Vars), 0)
+	for i := len(stmt.Vars) - 1; i >= 0; i-- {
+		c.define(stmt, stmt.Vars[i])
+	}
+
+	l := &loop{blocks: c.fs.blocks}
+	c.fs.loops = append(c.fs.loops, l)
+	err := c.discard(func() error {
+		return c.stmts(stmt.Do)
+	})
+	c.fs.loops = c.fs.loops[:len(c.fs.loops)-1]
+	if err != nil {
+		return err
+	}
+
+	for _, pc := range l.continues {
+		c.patch(pc)
+	}
+	c.emit(stmt, OpJump, start, 0, 0)
+
+	c.patch(exit)
+	for _, pc := range l.breaks {
+		c.patch(pc)
+	}
+	c.closeBlock(stmt)
+	return nil
+}
+
+// switchStmt 比较的值放在没有名字的局部变量中, case 的语句块按顺序排列,
+// fallthrough 时不跳到结尾
+//
+//	expr; STORE v
+//	LOAD v; a; ==; JUMP_IF_FALSE next; JUMP body1
+//	next: ...; JUMP default 或 end
+//	body1: 语句块; JUMP end
+//	end:
+func (c *compiler) switchStmt(stmt *parse.SwitchStmt) error {
+	if err := c.expr(stmt.Expr); err != nil {
+		return err
+	}
+	c.openBlock(stmt, false, func() {
+		c.newSlot("", false)
+	})
+	v := c.fs.scope.vars[""]
+	c.emit(stmt, OpStoreLocal, v.slot, 0, 0)
+
+	bodies := make([][]int, len(stmt.Cases))
+	def := -1
+	for i, cs := range stmt.Cases {
+		if cs.Exprs == nil {
+			def = i
+			continue
+		}
+		for _, e := range cs.Exprs {
+			c.emit(e, OpLoadLocal, v.slot, 0, 0)
+			if err := c.expr(e); err != nil {
+				return err
+			}
+			c.emit(e, OpBinary, binaryOperatorIndex["=="], 0, 0)
+			next := c.emit(e, OpJumpIfFalse, 0, 0, 0)
+			bodies[i] = append(bodies[i], c.emit(e, OpJump, 0, 0, 0))
+			c.patch(next)
+		}
+	}
+	var ends []int
+	if def >= 0 {
+		bodies[def] = append(bodies[def], c.emit(stmt, OpJump, 0, 0, 0))
+	} else {
+		// 没有执行的语句块
+		c.clearLast(stmt)
+		ends = append(ends, c.emit(stmt, OpJump, 0, 0, 0))
+	}
+
+	for i, cs := range stmt.Cases {
+		for _, pc := range bodies[i] {
+			c.patch(pc)
+		}
+		if err := c.block(cs, cs.Do); err != nil {
+			return err
+		}
+		if !fallsThrough(cs) {
+			ends = append(ends, c.emit(cs, OpJump, 0, 0, 0))
+		}
+	}
+	for _, pc := range ends {
+		c.patch(pc)
+	}
+	c.closeBlock(stmt)
+	return nil
+}
+
+// tryStmt 出错时跳到 catch, finally 在每个出口各编译一份
+//
+//	TRY h1; try 块; END_TRY; finally; JUMP end
+//	h1: catch 块 (其中再用 TRY h2 保护); END_TRY; finally; JUMP end
+//	h2: finally; RETHROW
+//	end:
+func (c *compiler) tryStmt(stmt *parse.TryStmt) error {
+	var ends []int
+
+	handler := c.openTry(stmt, stmt.Finally, c.fs.scope, c.fs.blocks)
+	if err := c.block(stmt, stmt.Try); err != nil {
+		return err
+	}
+	if err := c.closeTry(stmt); err != nil {
+		return err
+	}
+	ends = append(ends, c.emit(stmt, OpJump, 0, 0, 0))
+
+	// 栈顶是错误
+	c.patch(handler)
+	if stmt.Catch != nil {
+		if stmt.Var != "" {
+			c.emit(stmt, OpException, 0, 0, 0)
+		} else {
+			c.emit(stmt, OpDrop, 0, 0, 0)
+		}
+		scope, blocks := c.fs.scope, c.fs.blocks
+		c.openBlock(stmt, hasFuncExpr(stmt.Catch), func() {
+			if stmt.Var != "" {
+				c.newSlot(stmt.Var, false)
+			}
+			c.declare(stmt.Catch)
+		})
+		if stmt.Var != "" {
+			c.define(stmt, stmt.Var)
+		}
+		if stmt.Finally != nil {
+			// 出错时离开 catch 块
+			handler = c.openTry(stmt, stmt.Finally, scope, blocks)
+		}
+		if err := c.stmts(stmt.Catch); err != nil {
+			return err
+		}
+		c.closeBlock(stmt)
+		if stmt.Finally != nil {
+			if err := c.closeTry(stmt); err != nil {
+				return err
+			}
+		}
+		ends = append(ends, c.emit(stmt, OpJump, 0, 0, 0))
+	}
+
+	if stmt.Finally != nil {
+		c.patch(handler)
+		if err := c.finally(stmt, stmt.Finally); err != nil {
+			return err
+		}
+		c.emit(stmt, OpRethrow, 0, 0, 0)
+	}
+
+	for _, pc := range ends {
+		c.patch(pc)
+	}
+	return nil
+}
+
+// openTry 开始 try 保护的代码, scope 和 blocks 是 try 语句所在的位置,
+// 返回需要回填出错时跳转地址的指令
+func (c *compiler) openTry(pos parse.Pos, finally []parse.Stmt, scope *scope, blocks int) int {
+	c.fs.tries = append(c.fs.tries, &tryBlock{
+		finally: finally,
+		scope:   scope,
+		blocks:  blocks,
+		loops:   len(c.fs.loops),
+	})
+	return c.emit(pos, OpTry, 0, c.fs.blocks-blocks, 0)
+}
+
+// closeTry 正常执行完 try 保护的代码, 离开 try 后执行 finally
+func (c *compiler) closeTry(pos parse.Pos) error {
+	t := c.fs.tries[len(c.fs.tries)-1]
+	c.fs.tries = c.fs.tries[:len(c.fs.tries)-1]
+	c.emit(pos, OpEndTry, 0, 0, 0)
+	if t.finally == nil {
+		return nil
+	}
+	return c.finally(pos, t.finally)
+}
+
+// finally 编译 finally 块, try 语句的值是 try 或 catch 块的值
+func (c *compiler) finally(pos parse.Pos, stmts []parse.Stmt) error {
+	return c.discard(func() error {
+		return c.block(pos, stmts)
+	})
+}
+
+// loopTries 当前循环体内的 try 从第几个开始
+func (c *compiler) loopTries() int {
+	n := len(c.fs.tries)
+	for n > 0 && c.fs.tries[n-1].loops == len(c.fs.loops) {
+		n--
+	}
+	return n
+}
+
+// leaveTries break, continue, return 跳出第 n 个之后的 try 前, 从里到外离开 try 并执行 finally.
+// finally 在 try 语句所在的作用域中编译, 返回恢复编译状态的函数
+func (c *compiler) leaveTries(pos parse.Pos, n int) (func(), error) {
+	fs := c.fs
+	scope, blocks, loops, tries := fs.scope, fs.blocks, fs.loops, fs.tries
+	restore := func() {
+		fs.scope, fs.blocks, fs.loops, fs.tries = scope, blocks, loops, tries
+	}
+	for i := len(tries) - 1; i >= n; i-- {
+		t := tries[i]
+		if d := fs.blocks - t.blocks; d > 0 {
+			c.emit(pos, OpLeaveBlock, d, 0, 0)
+		}
+		fs.scope, fs.blocks, fs.loops, fs.tries = t.scope, t.blocks, loops[:t.loops], tries[:i]
+		c.emit(pos, OpEndTry, 0, 0, 0)
+		if t.finally != nil {
+			if err := c.finally(pos, t.finally); err != nil {
+				restore()
+				return nil, err
+			}
+		}
+	}
+	return restore, nil
+}
+
+//////////////////////////////
+// expr
+//////////////////////////////
+
+func (c *compiler) expr(expr parse.Expr) error {
+	switch e := expr.(type) {
+	case *parse.NumberExpr:
+		// 超出范围时编译出错
+		v, err := parseNumber(e.Lit)
+		if err != nil {
+			return NewError(expr, err)
+		}
+		c.emit(expr, OpConst, c.constant(v), 0, 0)
+	case *parse.StringExpr:
+		c.emit(expr, OpConst, c.constant(e.Lit), 0, 0)
+	case *parse.InterpExpr:
+		for i, lit := range e.Lits {
+			c.emit(expr, OpConst, c.constant(lit), 0, 0)
+			if i < len(e.Exprs) {
+				if err := c.expr(e.Exprs[i]); err != nil {
+					return err
+				}
+			}
+		}
+		c.emit(expr, OpConcat, len(e.Lits)+len(e.Exprs), 0, 0)
+	case *parse.IdentExpr:
+		c.load(expr, e.Lit)
+	case *parse.ConstExpr:
+		switch e.Value {
+		case "true":
+			c.emit(expr, OpConst, c.constant(true), 0, 0)
+		case "false":
+			c.emit(expr, OpConst, c.constant(false), 0, 0)
+		default:
+			c.emit(expr, OpNil, 0, 0, 0)
+		}
+	case *parse.ParenExpr:
+		return c.expr(e.SubExpr)
+	case *parse.UnaryExpr:
+		op, ok := unaryOperatorIndex[e.Operator]
+		if !ok {
+			return NewStringError(expr, "Unknown operator")
+		}
+		if err := c.expr(e.Expr); err != nil {
+			return err
+		}
+		c.emit(expr, OpUnary, op, 0, 0)
+	case *parse.BinOpExpr:
+		op, ok := binaryOperatorIndex[e.Operator]
+		if !ok {
+			return NewStringError(expr, "Unknown operator")
+		}
+		if err := c.expr(e.Lhs); err != nil {
+			return err
+		}
+		if e.Rhs == nil {
+			c.emit(expr, OpNil, 0, 0, 0)
+		} else if err := c.expr(e.Rhs); err != nil {
+			return err
+		}
+		c.emit(expr, OpBinary, op, 0, 0)
+	case *parse.ArrayExpr:
+		for _, sub := range e.Exprs {
+			if err := c.expr(sub); err != nil {
+				return err
+			}
+		}
+		c.emit(expr, OpArray, len(e.Exprs), 0, 0)
+	case *parse.MapExpr:
+		for i, key := range e.Keys {
+			if err := c.expr(key); err != nil {
+				return err
+			}
+			if err := c.expr(e.Values[i]); err != nil {
+				return err
+			}
+		}
+		c.emit(expr, OpMap, len(e.Keys), 0, 0)
+	case *parse.IndexExpr:
+		if err := c.expr(e.Value); err != nil {
+			return err
+		}
+		if err := c.expr(e.Index); err != nil {
+			return err
+		}
+		c.emit(expr, OpIndex, 0, 0, 0)
+	case *parse.MemberExpr:
+		if err := c.expr(e.Expr); err != nil {
+			return err
+		}
+		c.emit(expr, OpMember, c.constant(e.Name), 0, 0)
+	case *parse.SliceExpr:
+		if err := c.expr(e.Value); err != nil {
+			return err
+		}
+		hasBegin, hasEnd := 0, 0
+		if e.Begin != nil {
+			hasBegin = 1
+			if err := c.expr(e.Begin); err != nil {
+				return err
+			}
+		}
+		if e.End != nil {
+			hasEnd = 1
+			if err := c.expr(e.End); err != nil {
+				return err
+			}
+		}
+		c.emit(expr, OpSlice, hasBegin, hasEnd, 0)
+	case *parse.FuncExpr:
+		return c.funcExpr(e)
+	case *parse.CallExpr:
+		if e.Func != nil {
+			if err := c.expr(e.Func); err != nil {
+				return err
+			}
+		} else {
+			c.load(expr, e.Name)
+		}
+		for _, arg := range e.SubExprs {
+			if err := c.expr(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(expr, OpCall, len(e.SubExprs), 0, 0)
+	case *parse.LetsExpr:
+		if err := c.lets(expr, e.Lhss, e.Rhss); err != nil {
+			return err
+		}
+		c.emit(expr, OpNil, 0, 0, 0)
+	default:
+		return NewStringError(expr, "为止的表达式")
+	}
+	return nil
+}
+
+func (c *compiler) funcExpr(e *parse.FuncExpr) error {
+	p := &Proto{Name: e.Name, File: e.File, NumParams: len(e.Args)}
+
+	c.openFunc(p, false)
+	for _, arg := range e.Args {
+		c.newSlot(arg, false)
+	}
+	c.declare(e.Stmts)
+	err := c.stmts(e.Stmts)
+	c.emit(e, OpReturnLast, 0, 0, 0)
+	c.closeFunc()
+	if err != nil {
+		return err
+	}
+
+	parent := c.fs.proto
+	parent.Protos = append(parent.Protos, p)
+	c.emit(e, OpClosure, len(parent.Protos)-1, 0, 0)
+
+	if e.Name != "" {
+		c.emit(e, OpDup, 0, 0, 0)
+		c.define(e, e.Name)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package vm
+
+import (
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 调试
+//////////////////////////////
+
+// Hook 调试钩子, 遍历语法树执行时每条语句执行前调用, 返回错误时停止执行
+type Hook func(stmt parse.Stmt, env *Env) error
+
+// StackFrame 调试时调用栈中的一层
+type StackFrame struct {
+	Frame
+	Env *Env // 正在执行的语句所在的环境
+}
+
+// SetHook 设置调试钩子, 对所有子环境有效, 为 nil 时取消. 字节码引擎不会调用钩子
+func (e *Env) SetHook(h Hook) {
+	e.ctl.hook = h
+	e.ctl.frames = nil
+}
+
+// Stack 设置了钩子时的调用栈, 最里层在前, 最后一层是顶层代码
+func (e *Env) Stack() []StackFrame {
+	frames := make([]StackFrame, len(e.ctl.frames))
+	for i, f := range e.ctl.frames {
+		frames[len(frames)-1-i] = f
+	}
+	return frames
+}
+
+// Depth 设置了钩子时的调用深度, 顶层代码为 1
+func (e *Env) Depth() int {
+	return len(e.ctl.frames)
+}
+
+// hookStmt 记录当前语句的位置和环境, 然后调用钩子
+func (c *control) hookStmt(stmt parse.Stmt, env *Env) error {
+	if len(c.frames) == 0 {
+		c.frames = append(c.frames, StackFrame{Frame: Frame{Func: "main"}})
+	}
+	top := &c.frames[len(c.frames)-1]
+	top.Pos = stmt.Position()
+	top.Env = env
+	return c.hook(stmt, env)
+}
@@ -8,12 +8,17 @@ import (
 
 // Env 环境
 type Env struct {
-	// 包名
-	//name string
+	// 包名, 导入的模块和 Go 包才有
+	name   string
+	// 模块的顶层环境, 赋值不会修改导入它的程序的变量
+	module bool
 	env    map[string]reflect.Value
 	typ    map[string]reflect.Type
 	parent *Env
-	//interrupt *bool
+	// 被闭包引用时不能销毁
+	captured bool
+	// 中断和执行限制, 子环境共用全局环境的
+	ctl *control
 	sync.RWMutex
 }
 
@@ -23,6 +28,7 @@ func NewEnv() *Env {
 		env:    make(map[string]reflect.Value),
 		typ:    make(map[string]reflect.Type),
 		parent: nil,
+		ctl:    &control{modules: &modules{loaded: make(map[string]*Env)}},
 	}
 }
 
@@ -32,6 +38,7 @@ func (e *Env) NewEnv() *Env {
 		env:    make(map[string]reflect.Value),
 		typ:    make(map[string]reflect.Type),
 		parent: e,
+		ctl:    e.ctl,
 	}
 }
 
@@ -40,7 +47,7 @@ func (e *Env) Destroy() {
 	e.Lock()
 	defer e.Unlock()
 
-	if e.parent == nil {
+	if e.parent == nil || e.captured {
 		return
 	}
 	for k, v := range e.parent.env {
@@ -52,12 +59,45 @@ func (e *Env) Destroy() {
 	e.env = nil
 }
 
-//// 包名
-//func (e *Env) SetName(n string) {
-//    e.Lock()
-//    e.name = n
-//    e.Unlock()
-//}
+// capture 标记环境被闭包引用, 外层环境也一起保留
+func (e *Env) capture() {
+	for ; e != nil; e = e.parent {
+		e.Lock()
+		captured := e.captured
+		e.captured = true
+		e.Unlock()
+		if captured {
+			return
+		}
+	}
+}
+
+// Parent 外层环境, 全局环境返回 nil
+func (e *Env) Parent() *Env {
+	return e.parent
+}
+
+// Values 环境中定义的变量, 不包括外层环境
+func (e *Env) Values() map[string]reflect.Value {
+	e.RLock()
+	defer e.RUnlock()
+
+	values := make(map[string]reflect.Value, len(e.env))
+	for k, v := range e.env {
+		values[k] = v
+	}
+	return values
+}
+
+// Name 包名, 不是模块或 Go 包时为空
+func (e *Env) Name() string {
+	return e.name
+}
+
+// String 作为值打印时的格式
+func (e *Env) String() string {
+	return fmt.Sprintf("[Package: %s]", e.name)
+}
 
 // Type 返回类型
 func (e *Env) Type(k string) (reflect.Type, error) {
@@ -100,7 +140,7 @@ func (e *Env) Set(k string, v interface{}) error {
 		e.env[k] = val
 		return nil
 	}
-	if e.parent == nil {
+	if e.parent == nil || e.module {
 		return fmt.Errorf("Unknown symbol '%s'", k)
 
 	}
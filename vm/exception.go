@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 异常
+//////////////////////////////
+
+// throwError throw 的值转换为错误, 字典中有字符串 message 时作为错误信息
+func throwError(v reflect.Value, pos parse.Position) *Error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	message := toString(v)
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.Interface {
+		if m := v.MapIndex(reflect.ValueOf("message")); m.IsValid() {
+			if m.Kind() == reflect.Interface {
+				m = m.Elem()
+			}
+			if m.Kind() == reflect.String {
+				message = m.String()
+			}
+		}
+	}
+	e := newError(message, pos)
+	if v.IsValid() && v != NilValue && v.CanInterface() {
+		e.Value = v.Interface()
+	}
+	return e
+}
+
+// exception catch 得到的值, 包含错误信息, throw 的值和出错的位置
+func (e *Error) exception() reflect.Value {
+	return reflect.ValueOf(map[interface{}]interface{}{
+		"message": e.Message,
+		"value":   e.Value,
+		"line":    int64(e.Pos.Line),
+		"column":  int64(e.Pos.Column),
+	})
+}
+
+// runTry 只有 *Error 会被 catch, break, continue, return 和中断直接穿过, finally 总是执行
+func runTry(stmt *parse.TryStmt, env *Env) (reflect.Value, error) {
+	newEnv := env.NewEnv()
+	rv, err := run(stmt.Try, newEnv)
+	newEnv.Destroy()
+
+	if ee, ok := err.(*Error); ok && stmt.Catch != nil {
+		catchEnv := env.NewEnv()
+		if stmt.Var != "" {
+			catchEnv.Define(stmt.Var, ee.exception())
+		}
+		rv, err = run(stmt.Catch, catchEnv)
+		catchEnv.Destroy()
+	}
+
+	if stmt.Finally != nil {
+		finallyEnv := env.NewEnv()
+		frv, ferr := run(stmt.Finally, finallyEnv)
+		finallyEnv.Destroy()
+		// finally 中的 break, return 和错误代替原来的结果
+		if ferr != nil {
+			return frv, NewError(stmt, ferr)
+		}
+	}
+	if err != nil {
+		return rv, NewError(stmt, err)
+	}
+	return rv, nil
+}
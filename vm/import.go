@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 模块
+//////////////////////////////
+
+// Ext 脚本文件的扩展名, import 的路径没有扩展名时加上
+const Ext = ".gg"
+
+// modules 导入过的模块, 全局环境和它的所有子环境共用
+type modules struct {
+	path    []string        // 搜索路径
+	loaded  map[string]*Env // 按绝对路径记录, 每个文件只执行一次
+	loading []string        // 正在导入的文件, 用来发现循环导入
+}
+
+// SetImportPath 设置 import 的搜索路径, 在导入模块的文件所在目录找不到时依次查找
+func (e *Env) SetImportPath(dirs []string) {
+	e.ctl.modules.path = dirs
+}
+
+// runner 执行模块的顶层代码, 两个引擎各自提供
+type runner func(stmts []parse.Stmt, env *Env) (reflect.Value, error)
+
+// importModule 找到并执行模块, 返回模块的环境, 已经导入过的直接返回
+func importModule(stmt *parse.ImportStmt, env *Env, exec runner) (*Env, error) {
+	mods := env.ctl.modules
+	file, err := mods.find(stmt.Path, stmt.File)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := mods.loaded[file]; ok {
+		return m, nil
+	}
+	// 从主程序开始导入时主程序也算正在导入
+	if len(mods.loading) == 0 && stmt.File != "" {
+		if abs, err := filepath.Abs(stmt.File); err == nil {
+			mods.loading = []string{abs}
+			defer func() { mods.loading = nil }()
+		}
+	}
+	for i, f := range mods.loading {
+		if f == file {
+			cycle := append(append([]string{}, mods.loading[i:]...), file)
+			return nil, fmt.Errorf("import cycle not allowed: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parse.ParseFile(file, string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	// 模块的环境在全局环境下面, 可以使用内置函数, 没有定义的变量赋值时定义在模块中
+	root := env
+	for root.parent != nil {
+		root = root.parent
+	}
+	m := root.NewEnv()
+	m.name = stmt.Name
+	m.module = true
+
+	mods.loading = append(mods.loading, file)
+	defer func() {
+		mods.loading = mods.loading[:len(mods.loading)-1]
+	}()
+
+	// 顶层代码在调用栈中是一层
+	if err := env.ctl.enter("<module>", file, stmt.Position()); err != nil {
+		return nil, err
+	}
+	defer env.ctl.leave()
+
+	_, err = exec(t.Root, m)
+	if ee, ok := err.(*Error); ok {
+		ee.leave("<module>", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mods.loaded[file] = m
+	return m, nil
+}
+
+// find 先在导入模块的文件所在目录找, 然后依次在搜索路径中找
+func (mods *modules) find(path, from string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("invalid import path %q", path)
+	}
+	if filepath.Ext(path) == "" {
+		path += Ext
+	}
+	path = filepath.FromSlash(path)
+
+	var dirs []string
+	if filepath.IsAbs(path) {
+		dirs = []string{""}
+	} else {
+		dirs = append([]string{filepath.Dir(from)}, mods.path...)
+	}
+	for _, dir := range dirs {
+		file := filepath.Join(dir, path)
+		if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+			if abs, err := filepath.Abs(file); err == nil {
+				file = abs
+			}
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find module %q", path)
+}
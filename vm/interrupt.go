@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 中断
+//////////////////////////////
+
+// Limits 执行限制, 为 0 的字段不限制
+type Limits struct {
+	MaxSteps     int64 // 最多执行的步数, 每次循环和函数调用算一步
+	MaxCallDepth int   // 脚本函数的最大调用深度
+}
+
+// InterruptError 执行被取消或超过限制
+type InterruptError struct {
+	Reason string
+	Err    error // 被 context 取消时为 ctx.Err()
+	Pos    parse.Position
+}
+
+func (e *InterruptError) Error() string {
+	return "execution interrupted: " + e.Reason
+}
+
+// Unwrap 可以用 errors.Is(err, context.Canceled) 判断
+func (e *InterruptError) Unwrap() error {
+	return e.Err
+}
+
+// control 执行控制, 全局环境和它的所有子环境共用一个
+type control struct {
+	ctx    context.Context
+	done   <-chan struct{}
+	limits Limits
+	steps  int64
+	depth  int
+
+	hook   Hook
+	frames []StackFrame // 设置了 hook 时的调用栈, 最外层在前
+
+	modules *modules
+}
+
+// step 在循环的每一轮和每次调用时检查是否要中断
+func (c *control) step(pos parse.Position) error {
+	if c.done != nil {
+		select {
+		case <-c.done:
+			err := c.ctx.Err()
+			return &InterruptError{Reason: err.Error(), Err: err, Pos: pos}
+		default:
+		}
+	}
+	if c.limits.MaxSteps > 0 {
+		c.steps++
+		if c.steps > c.limits.MaxSteps {
+			return &InterruptError{Reason: fmt.Sprintf("step limit %d exceeded", c.limits.MaxSteps), Pos: pos}
+		}
+	}
+	return nil
+}
+
+// enter 进入脚本函数, 成功时调用者要在返回时调用 leave
+func (c *control) enter(name, file string, pos parse.Position) error {
+	if c.limits.MaxCallDepth > 0 && c.depth >= c.limits.MaxCallDepth {
+		return &InterruptError{Reason: fmt.Sprintf("call depth %d exceeded", c.limits.MaxCallDepth), Pos: pos}
+	}
+	c.depth++
+	if c.hook != nil {
+		if name == "" {
+			name = "<anonymous>"
+		}
+		c.frames = append(c.frames, StackFrame{Frame: Frame{Func: name, File: file, Pos: pos}})
+	}
+	return nil
+}
+
+func (c *control) leave() {
+	c.depth--
+	if c.hook != nil && len(c.frames) > 0 {
+		c.frames = c.frames[:len(c.frames)-1]
+	}
+}
+
+// start 开始一次带 context 的执行, 返回的函数恢复之前的状态
+func (c *control) start(ctx context.Context) func() {
+	old := *c
+	c.ctx = ctx
+	c.done = ctx.Done()
+	c.steps = 0
+	return func() {
+		*c = old
+	}
+}
+
+// SetLimits 设置环境的执行限制, 对所有子环境和之后的执行都有效
+func (e *Env) SetLimits(l Limits) {
+	e.ctl.limits = l
+}
+
+// RunContext 执行语句, ctx 被取消或超过限制时返回 *InterruptError
+func RunContext(ctx context.Context, stmts []parse.Stmt, env *Env) (reflect.Value, error) {
+	defer env.ctl.start(ctx)()
+	return Run(stmts, env)
+}
+
+// RunCompiledContext 用字节码执行语句, ctx 被取消或超过限制时返回 *InterruptError
+func RunCompiledContext(ctx context.Context, stmts []parse.Stmt, env *Env) (reflect.Value, error) {
+	defer env.ctl.start(ctx)()
+	return RunCompiled(stmts, env)
+}
@@ -0,0 +1,57 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+// TestInterrupt 两个引擎在取消和超过限制时都返回 *InterruptError
+func TestInterrupt(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		limits Limits
+		cancel bool
+		reason string
+	}{
+		{"cancel", "for i = 0; true; i = i + 1 {}", Limits{}, true, "context canceled"},
+		{"steps", "for i = 0; true; i = i + 1 {}", Limits{MaxSteps: 100}, false, "step limit 100 exceeded"},
+		{"depth", "func f() { return f(); } f();", Limits{MaxCallDepth: 50}, false, "call depth 50 exceeded"},
+	}
+	engines := map[string]func(context.Context, []parse.Stmt, *Env) (reflect.Value, error){
+		"tree":     RunContext,
+		"bytecode": RunCompiledContext,
+	}
+	for _, tt := range tests {
+		tree, err := parse.Parse(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, run := range engines {
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			}
+			env := NewEnv()
+			env.SetLimits(tt.limits)
+			_, err := run(ctx, tree.Root, env)
+			cancel()
+
+			var ie *InterruptError
+			if !errors.As(err, &ie) {
+				t.Errorf("%s %s: got %v, want *InterruptError", name, tt.name, err)
+				continue
+			}
+			if ie.Reason != tt.reason {
+				t.Errorf("%s %s: got %q, want %q", name, tt.name, ie.Reason, tt.reason)
+			}
+			if tt.cancel && !errors.Is(err, context.Canceled) {
+				t.Errorf("%s %s: %v is not context.Canceled", name, tt.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package vm
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// 字节码虚拟机
+//////////////////////////////
+
+// frame 函数调用的局部变量
+type frame struct {
+	slots   []reflect.Value
+	defined []bool
+	parent  *frame // 外层语句块或定义函数时所在的 frame
+}
+
+func newFrame(p *Proto, parent *frame) *frame {
+	return &frame{
+		slots:   make([]reflect.Value, p.NumSlots),
+		defined: make([]bool, p.NumSlots),
+		parent:  parent,
+	}
+}
+
+func (f *frame) up(depth int) *frame {
+	for ; depth > 0; depth-- {
+		f = f.parent
+	}
+	return f
+}
+
+type machine struct {
+	env *Env
+}
+
+// Exec 在环境中执行编译后的字节码
+func Exec(p *Proto, env *Env) (reflect.Value, error) {
+	m := &machine{env: env}
+	rv, err := m.run(p, newFrame(p, nil))
+	return rv, finishTrace(err)
+}
+
+// RunCompiled 编译并执行语法树
+func RunCompiled(stmts []parse.Stmt, env *Env) (reflect.Value, error) {
+	p, err := Compile(stmts)
+	if err != nil {
+		// 编译错误也在顶层代码这一层
+		return NilValue, finishTrace(err)
+	}
+	return Exec(p, env)
+}
+
+// call 调用脚本函数
+func (m *machine) call(p *Proto, parent *frame, args []reflect.Value) (reflect.Value, error) {
+	if err := m.env.ctl.enter(p.Name, p.File, p.Pos[0]); err != nil {
+		return NilValue, err
+	}
+	defer m.env.ctl.leave()
+	fr := newFrame(p, parent)
+	// 少传的参数为 nil, 多传的忽略
+	for i := 0; i < p.NumParams; i++ {
+		if i < len(args) {
+			fr.slots[i] = args[i]
+		} else {
+			fr.slots[i] = NilValue
+		}
+		fr.defined[i] = true
+	}
+	rv, err := m.run(p, fr)
+	if ee, ok := err.(*Error); ok {
+		ee.leave(p.Name, p.File)
+	}
+	return rv, err
+}
+
+// runModule 在模块自己的环境中执行模块的顶层代码
+func (m *machine) runModule(stmts []parse.Stmt, env *Env) (reflect.Value, error) {
+	p, err := Compile(stmts)
+	if err != nil {
+		return NilValue, err
+	}
+	mm := &machine{env: env}
+	return mm.run(p, newFrame(p, nil))
+}
+
+// closure 生成可以被 callFunc 调用的函数值
+func (m *machine) closure(p *Proto, parent *frame) reflect.Value {
+	return reflect.ValueOf(Func(func(args ...reflect.Value) (reflect.Value, error) {
+		return m.call(p, parent, args)
+	}))
+}
+
+// handler OpTry 记录的出错时恢复的状态
+type handler struct {
+	pc int
+	sp int
+	fr *frame
+}
+
+func (m *machine) run(p *Proto, fr *frame) (reflect.Value, error) {
+	var err error
+	var handlers []handler
+	stack := make([]reflect.Value, 0, 16)
+	last := NilValue
+
+	code := p.Code
+	for pc := 0; pc < len(code); pc++ {
+		ins := code[pc]
+		switch ins.Op {
+		case OpNop:
+		case OpConst:
+			stack = append(stack, p.Consts[ins.A])
+		case OpNil:
+			stack = append(stack, reflect.Value{})
+		case OpPop:
+			last = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case OpDrop:
+			stack = stack[:len(stack)-1]
+		case OpDup:
+			stack = append(stack, stack[len(stack)-1])
+		case OpReverse:
+			top := stack[len(stack)-ins.A:]
+			for i, j := 0, len(top)-1; i < j; i, j = i+1, j-1 {
+				top[i], top[j] = top[j], top[i]
+			}
+		case OpLoadGlobal:
+			var v reflect.Value
+			v, err = m.env.Get(p.Consts[ins.A].String())
+			stack = append(stack, v)
+		case OpStoreGlobal:
+			v := letValue(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			name := p.Consts[ins.A].String()
+			if m.env.Set(name, v) != nil {
+				m.env.Define(name, v)
+			}
+		case OpDefineGlobal:
+			m.env.Define(p.Consts[ins.A].String(), stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+		case OpLoadLocal:
+			stack = append(stack, fr.up(ins.B).slots[ins.A])
+		case OpStoreLocal:
+			f := fr.up(ins.B)
+			f.slots[ins.A] = stack[len(stack)-1]
+			f.defined[ins.A] = true
+			stack = stack[:len(stack)-1]
+		case OpLoadName:
+			f := fr.up(ins.B)
+			if f.defined[ins.A] {
+				stack = append(stack, f.slots[ins.A])
+				break
+			}
+			var v reflect.Value
+			v, err = m.env.Get(p.Consts[ins.C].String())
+			stack = append(stack, v)
+		case OpStoreName:
+			v := letValue(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			f := fr.up(ins.B)
+			if f.defined[ins.A] || m.env.Set(p.Consts[ins.C].String(), v) != nil {
+				f.slots[ins.A] = v
+				f.defined[ins.A] = true
+			}
+		case OpClear:
+			for i := ins.A; i < ins.B; i++ {
+				fr.slots[i] = reflect.Value{}
+				fr.defined[i] = false
+			}
+		case OpEnterBlock:
+			fr = &frame{
+				slots:   make([]reflect.Value, ins.A),
+				defined: make([]bool, ins.A),
+				parent:  fr,
+			}
+		case OpLeaveBlock:
+			fr = fr.up(ins.A)
+		case OpUnary:
+			var v reflect.Value
+			v, err = evalUnaryOp(unaryOperators[ins.A], stack[len(stack)-1])
+			stack[len(stack)-1] = v
+		case OpBinary:
+			lhsV, rhsV := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			if lhsV.Kind() == reflect.Interface {
+				lhsV = lhsV.Elem()
+			}
+			if rhsV.Kind() == reflect.Interface {
+				rhsV = rhsV.Elem()
+			}
+			var v reflect.Value
+			v, err = evalBinOp(binaryOperators[ins.A], lhsV, rhsV)
+			stack = append(stack, v)
+		case OpArray:
+			a := make([]interface{}, ins.A)
+			for i, v := range stack[len(stack)-ins.A:] {
+				if v.IsValid() && v.CanInterface() {
+					a[i] = v.Interface()
+				}
+			}
+			stack = append(stack[:len(stack)-ins.A], reflect.ValueOf(a))
+		case OpMap:
+			m := reflect.ValueOf(make(map[interface{}]interface{}, ins.A))
+			pairs := stack[len(stack)-2*ins.A:]
+			for i := 0; i < len(pairs) && err == nil; i += 2 {
+				err = setIndex(m, pairs[i], pairs[i+1])
+			}
+			stack = append(stack[:len(stack)-2*ins.A], m)
+		case OpIndex:
+			v, i := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			v, err = indexValue(v, i)
+			stack = append(stack, v)
+		case OpSlice:
+			begin, end := NilValue, NilValue
+			if ins.B != 0 {
+				end = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			if ins.A != 0 {
+				begin = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			v := stack[len(stack)-1]
+			v, err = sliceValue(v, begin, end, ins.A != 0, ins.B != 0)
+			stack[len(stack)-1] = v
+		case OpSetIndex:
+			rv, v, i := stack[len(stack)-3], stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-3]
+			err = setIndex(v, i, letValue(rv))
+		case OpConcat:
+			var buf bytes.Buffer
+			for _, v := range stack[len(stack)-ins.A:] {
+				buf.WriteString(toString(v))
+			}
+			stack = append(stack[:len(stack)-ins.A], reflect.ValueOf(buf.String()))
+		case OpJump:
+			// 往回跳是循环的下一轮
+			if ins.A <= pc {
+				err = m.env.ctl.step(p.Pos[pc])
+			}
+			pc = ins.A - 1
+		case OpJumpIfFalse:
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !toBool(v) {
+				pc = ins.A - 1
+			}
+		case OpIter:
+			var it *iterator
+			it, err = newIterator(stack[len(stack)-1], ins.A, m.env.ctl)
+			stack[len(stack)-1] = reflect.ValueOf(it)
+		case OpNext:
+			it := stack[len(stack)-1].Interface().(*iterator)
+			stack = stack[:len(stack)-1]
+			k, v, ok, e := it.next(p.Pos[pc])
+			switch {
+			case e != nil:
+				err = e
+			case !ok:
+				pc = ins.A - 1
+			case ins.B == 1:
+				stack = append(stack, k)
+			default:
+				stack = append(stack, k, v)
+			}
+		case OpCall:
+			n := len(stack) - ins.A
+			f := stack[n-1]
+			args := make([]reflect.Value, ins.A)
+			copy(args, stack[n:])
+			stack = stack[:n-1]
+			var v reflect.Value
+			if err = m.env.ctl.step(p.Pos[pc]); err == nil {
+				v, err = callFunc(f, args)
+			}
+			stack = append(stack, v)
+		case OpMember:
+			var v reflect.Value
+			v, err = member(stack[len(stack)-1], p.Consts[ins.A].String())
+			stack[len(stack)-1] = v
+		case OpSetMember:
+			rv, v := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			err = setMember(v, p.Consts[ins.A].String(), letValue(rv))
+		case OpImport:
+			var mod *Env
+			mod, err = importModule(p.Imports[ins.A], m.env, m.runModule)
+			stack = append(stack, reflect.ValueOf(mod))
+		case OpClosure:
+			stack = append(stack, m.closure(p.Protos[ins.A], fr))
+		case OpReturn:
+			return stack[len(stack)-1], nil
+		case OpReturnLast:
+			return last, nil
+		case OpTry:
+			handlers = append(handlers, handler{pc: ins.A, sp: len(stack), fr: fr.up(ins.B)})
+		case OpEndTry:
+			handlers = handlers[:len(handlers)-1]
+		case OpException:
+			stack[len(stack)-1] = stack[len(stack)-1].Interface().(*Error).exception()
+		case OpThrow:
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			err = throwError(v, p.Pos[pc])
+		case OpRethrow:
+			err = stack[len(stack)-1].Interface().(*Error)
+			stack = stack[:len(stack)-1]
+		default:
+			err = NewStringError(nil, "unknown instruction "+ins.Op.String())
+		}
+		if err != nil {
+			err = m.error(p, pc, err)
+			// 只有 *Error 会被 catch
+			ee, ok := err.(*Error)
+			if !ok || len(handlers) == 0 {
+				return NilValue, err
+			}
+			h := handlers[len(handlers)-1]
+			handlers = handlers[:len(handlers)-1]
+			stack = append(stack[:h.sp], reflect.ValueOf(ee))
+			fr = h.fr
+			pc = h.pc - 1
+			err = nil
+		}
+	}
+	return last, nil
+}
+
+// error 给错误加上指令对应的位置
+func (m *machine) error(p *Proto, pc int, err error) error {
+	if ee, ok := err.(*Error); ok {
+		ee.callAt(p.Pos[pc])
+		return ee
+	}
+	if _, ok := err.(*InterruptError); ok {
+		return err
+	}
+	if _, ok := err.(parse.ErrorList); ok {
+		return err
+	}
+	return newError(err.Error(), p.Pos[pc])
+}
+
+// letValue 和树遍历的赋值一样, 把值转换成具体类型
+func letValue(rv reflect.Value) reflect.Value {
+	if rv == NilValue || !rv.IsValid() || !rv.CanInterface() {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(rv.Interface())
+}
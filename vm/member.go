@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//////////////////////////////
+// 成员
+//////////////////////////////
+
+// member 取模块和 Go 包中定义的名字, 或者 Go 值的字段和方法.
+// 模块只找自己的顶层定义, 找不到时再找包中的类型, 方法取出来是绑定了接收者的函数
+func member(v reflect.Value, name string) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if m, ok := namespace(v); ok {
+		m.RLock()
+		defer m.RUnlock()
+		if rv, ok := m.env[name]; ok {
+			return rv, nil
+		}
+		if t, ok := m.typ[name]; ok {
+			return reflect.ValueOf(t), nil
+		}
+		return NilValue, fmt.Errorf("undefined: %s.%s", m.name, name)
+	}
+	if !v.IsValid() || v == NilValue {
+		return NilValue, fmt.Errorf("cannot access member %s of nil", name)
+	}
+
+	if method := v.MethodByName(name); method.IsValid() {
+		return method, nil
+	}
+	// 可以取地址的值也能调用指针接收者的方法
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if method := v.Addr().MethodByName(name); method.IsValid() {
+			return method, nil
+		}
+	}
+
+	f, err := field(v, name)
+	if err != nil {
+		return NilValue, err
+	}
+	return f, nil
+}
+
+// setMember 给模块中已经定义的变量或者 Go 结构体的字段赋值
+func setMember(v reflect.Value, name string, rv reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if m, ok := namespace(v); ok {
+		m.Lock()
+		defer m.Unlock()
+		if _, ok := m.env[name]; !ok {
+			return fmt.Errorf("undefined: %s.%s", m.name, name)
+		}
+		m.env[name] = rv
+		return nil
+	}
+	if !v.IsValid() || v == NilValue {
+		return fmt.Errorf("cannot assign to member %s of nil", name)
+	}
+
+	f, err := field(v, name)
+	if err != nil {
+		return err
+	}
+	// 结构体本身不是指针时, 字段是副本
+	if !f.CanSet() {
+		return fmt.Errorf("cannot assign to field %s of unaddressable %s", name, typeName(v))
+	}
+	rv, err = convertTo(rv, f.Type())
+	if err != nil {
+		return err
+	}
+	f.Set(rv)
+	return nil
+}
+
+// field 取结构体或结构体指针的导出字段, 包括嵌入结构体的字段
+func field(v reflect.Value, name string) (reflect.Value, error) {
+	s := v
+	if s.Kind() == reflect.Ptr {
+		if s.IsNil() {
+			return NilValue, fmt.Errorf("cannot access member %s of nil %s", name, typeName(v))
+		}
+		s = s.Elem()
+	}
+	if s.Kind() != reflect.Struct {
+		return NilValue, fmt.Errorf("type %s has no member %s", typeName(v), name)
+	}
+	sf, ok := s.Type().FieldByName(name)
+	if !ok {
+		return NilValue, fmt.Errorf("type %s has no field or method %s", typeName(v), name)
+	}
+	if sf.PkgPath != "" {
+		return NilValue, fmt.Errorf("cannot refer to unexported field %s of %s", name, typeName(v))
+	}
+	f, err := s.FieldByIndexErr(sf.Index)
+	if err != nil {
+		return NilValue, fmt.Errorf("cannot access member %s of %s: %v", name, typeName(v), err)
+	}
+	return f, nil
+}
+
+// namespace 判断值是不是模块
+func namespace(v reflect.Value) (*Env, bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	m, ok := v.Interface().(*Env)
+	return m, ok && m != nil
+}
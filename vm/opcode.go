@@ -0,0 +1,186 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+// Opcode 字节码指令
+type Opcode int
+
+const (
+	OpNop          Opcode = iota
+	OpConst               // 压入常量 K[A]
+	OpNil                 // 压入 nil
+	OpPop                 // 弹出栈顶, 并记为最后的值
+	OpDrop                // 弹出栈顶, 不改变最后的值
+	OpDup                 // 复制栈顶
+	OpReverse             // 反转栈顶 A 个值
+	OpLoadGlobal          // 压入全局变量 K[A]
+	OpStoreGlobal         // 赋值全局变量 K[A]
+	OpDefineGlobal        // 定义全局变量 K[A]
+	OpLoadLocal           // 压入外面第 B 层 frame 的局部变量 A
+	OpStoreLocal          // 定义外面第 B 层 frame 的局部变量 A
+	OpLoadName            // 局部变量 A 未定义时取全局变量 K[C]
+	OpStoreName           // 局部变量 A 未定义且全局变量 K[C] 存在时赋值全局变量
+	OpClear               // 清除局部变量 [A, B)
+	OpEnterBlock          // 进入语句块, 新建 A 个变量的 frame
+	OpLeaveBlock          // 离开 A 层语句块的 frame
+	OpUnary               // 一元运算 unaryOperators[A]
+	OpBinary              // 二元运算 binaryOperators[A]
+	OpArray               // 用栈顶 A 个值生成数组
+	OpMap                 // 用栈顶 A 对键值生成字典
+	OpIndex               // 取下标
+	OpSlice               // 切片, A 为 begin 是否存在, B 为 end 是否存在
+	OpSetIndex            // 给下标赋值
+	OpConcat              // 把栈顶 A 个值转换为字符串并连接
+	OpMember              // 取模块中的名字 K[A]
+	OpSetMember           // 给模块中的名字 K[A] 赋值
+	OpImport              // 导入模块 Imports[A], 压入模块
+	OpJump                // 跳转到 A
+	OpJumpIfFalse         // 弹出栈顶, 为假时跳转到 A
+	OpIter                // 弹出栈顶, 压入 A 个变量遍历它的迭代器
+	OpNext                // 弹出迭代器, 压入下一组 B 个值, 结束时跳转到 A
+	OpCall                // 调用函数, 参数 A 个
+	OpClosure             // 压入函数 Protos[A]
+	OpReturn              // 返回栈顶
+	OpReturnLast          // 返回最后的值
+	OpTry                 // 开始 try, 出错时回到外面第 B 层 frame, 压入错误并跳转到 A
+	OpEndTry              // 结束最里层的 try
+	OpException           // 把栈顶的错误转换为 catch 得到的值
+	OpThrow               // 弹出栈顶并抛出
+	OpRethrow             // 弹出栈顶的错误并重新抛出
+)
+
+var opcodeNames = [...]string{
+	OpNop:          "NOP",
+	OpConst:        "CONST",
+	OpNil:          "NIL",
+	OpPop:          "POP",
+	OpDrop:         "DROP",
+	OpDup:          "DUP",
+	OpReverse:      "REVERSE",
+	OpLoadGlobal:   "LOAD_GLOBAL",
+	OpStoreGlobal:  "STORE_GLOBAL",
+	OpDefineGlobal: "DEFINE_GLOBAL",
+	OpLoadLocal:    "LOAD_LOCAL",
+	OpStoreLocal:   "STORE_LOCAL",
+	OpLoadName:     "LOAD_NAME",
+	OpStoreName:    "STORE_NAME",
+	OpClear:        "CLEAR",
+	OpEnterBlock:   "ENTER_BLOCK",
+	OpLeaveBlock:   "LEAVE_BLOCK",
+	OpUnary:        "UNARY",
+	OpBinary:       "BINARY",
+	OpArray:        "ARRAY",
+	OpMap:          "MAP",
+	OpIndex:        "INDEX",
+	OpSlice:        "SLICE",
+	OpSetIndex:     "SET_INDEX",
+	OpConcat:       "CONCAT",
+	OpMember:       "MEMBER",
+	OpSetMember:    "SET_MEMBER",
+	OpImport:       "IMPORT",
+	OpJump:         "JUMP",
+	OpJumpIfFalse:  "JUMP_IF_FALSE",
+	OpIter:         "ITER",
+	OpNext:         "NEXT",
+	OpCall:         "CALL",
+	OpClosure:      "CLOSURE",
+	OpReturn:       "RETURN",
+	OpReturnLast:   "RETURN_LAST",
+	OpTry:          "TRY",
+	OpEndTry:       "END_TRY",
+	OpException:    "EXCEPTION",
+	OpThrow:        "THROW",
+	OpRethrow:      "RETHROW",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) && opcodeNames[op] != "" {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("Opcode(%d)", int(op))
+}
+
+// unaryOperators OpUnary 的操作数
+var unaryOperators = []string{"+", "-", "!", "^"}
+
+var unaryOperatorIndex = map[string]int{"+": 0, "-": 1, "!": 2, "^": 3}
+
+// binaryOperators OpBinary 的操作数
+var binaryOperators = []string{
+	"+", "-", "*", "/", "%",
+	"==", "!=", ">", ">=", "<", "<=",
+	"|", "||", "&", "&&", "in",
+}
+
+var binaryOperatorIndex = func() map[string]int {
+	m := make(map[string]int, len(binaryOperators))
+	for i, op := range binaryOperators {
+		m[op] = i
+	}
+	return m
+}()
+
+// Instr 一条指令
+type Instr struct {
+	Op Opcode
+	A  int
+	B  int
+	C  int
+}
+
+// Proto 编译后的函数, 顶层代码也是一个没有参数的函数
+type Proto struct {
+	Name      string
+	File      string // 定义函数的源文件
+	NumParams int
+	NumSlots  int
+	Code      []Instr
+	Pos       []parse.Position // 每条指令对应的位置
+	Consts    []reflect.Value  // 常量池
+	Protos    []*Proto         // 内部定义的函数
+	Imports   []*parse.ImportStmt
+}
+
+// String 反汇编
+func (p *Proto) String() string {
+	var buf bytes.Buffer
+	p.disasm(&buf, "")
+	return buf.String()
+}
+
+func (p *Proto) disasm(buf *bytes.Buffer, indent string) {
+	name := p.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	fmt.Fprintf(buf, "%sfunc %s: params=%d slots=%d\n", indent, name, p.NumParams, p.NumSlots)
+	for pc, ins := range p.Code {
+		fmt.Fprintf(buf, "%s%4d  %-14s %d %d %d", indent, pc, ins.Op, ins.A, ins.B, ins.C)
+		switch ins.Op {
+		case OpConst:
+			fmt.Fprintf(buf, "\t; %#v", p.Consts[ins.A].Interface())
+		case OpLoadGlobal, OpStoreGlobal, OpDefineGlobal:
+			fmt.Fprintf(buf, "\t; %s", p.Consts[ins.A].String())
+		case OpLoadName, OpStoreName:
+			fmt.Fprintf(buf, "\t; %s", p.Consts[ins.C].String())
+		case OpMember, OpSetMember:
+			fmt.Fprintf(buf, "\t; %s", p.Consts[ins.A].String())
+		case OpImport:
+			fmt.Fprintf(buf, "\t; %q", p.Imports[ins.A].Path)
+		case OpUnary:
+			fmt.Fprintf(buf, "\t; %s", unaryOperators[ins.A])
+		case OpBinary:
+			fmt.Fprintf(buf, "\t; %s", binaryOperators[ins.A])
+		}
+		buf.WriteString("\n")
+	}
+	for _, sub := range p.Protos {
+		sub.disasm(buf, indent+"    ")
+	}
+}
@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//////////////////////////////
+// Go 包
+//////////////////////////////
+
+// DefineType 定义类型, t 为 reflect.Type 或者这个类型的值
+func (e *Env) DefineType(k string, t interface{}) error {
+	typ, ok := t.(reflect.Type)
+	if !ok {
+		if t == nil {
+			return fmt.Errorf("invalid type for '%s'", k)
+		}
+		typ = reflect.TypeOf(t)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.typ[k] = typ
+	return nil
+}
+
+// DefinePackage 把 Go 包定义为名字空间, values 是函数, 变量和常量, types 是可以构造的类型.
+// 脚本中用 name.Func() 调用函数, 用 name.Type() 或 name.Type({"Field": v}) 构造类型
+func (e *Env) DefinePackage(name string, values map[string]interface{}, types map[string]interface{}) (*Env, error) {
+	root := e
+	for root.parent != nil {
+		root = root.parent
+	}
+	pkg := root.NewEnv()
+	pkg.name = name
+	for k, v := range values {
+		pkg.Define(k, v)
+	}
+	for k, t := range types {
+		if err := pkg.DefineType(k, t); err != nil {
+			return nil, err
+		}
+	}
+	e.Define(name, pkg)
+	return pkg, nil
+}
+
+// typeValue 判断值是不是包中的类型
+func typeValue(v reflect.Value) (reflect.Type, bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	t, ok := v.Interface().(reflect.Type)
+	return t, ok && t != nil
+}
+
+// construct 调用类型: 没有参数时是零值, 结构体返回指针以便给字段赋值;
+// 结构体可以用字典给字段赋值, 其他类型和 Go 一样是类型转换
+func construct(t reflect.Type, args []reflect.Value) (reflect.Value, error) {
+	if len(args) > 1 {
+		return NilValue, fmt.Errorf("too many arguments to conversion to %s", t)
+	}
+	if t.Kind() != reflect.Struct {
+		if len(args) == 0 {
+			return reflect.Zero(t), nil
+		}
+		return convertTo(args[0], t)
+	}
+
+	p := reflect.New(t)
+	if len(args) == 0 {
+		return p, nil
+	}
+	fields := args[0]
+	if fields.Kind() == reflect.Interface {
+		fields = fields.Elem()
+	}
+	if fields.Kind() != reflect.Map {
+		v, err := convertTo(fields, t)
+		if err != nil {
+			return NilValue, err
+		}
+		p.Elem().Set(v)
+		return p, nil
+	}
+	for _, k := range SortedKeys(fields) {
+		if k.Kind() == reflect.Interface {
+			k = k.Elem()
+		}
+		if k.Kind() != reflect.String {
+			return NilValue, fmt.Errorf("field name of %s must be string, not %s", t, typeName(k))
+		}
+		if err := setMember(p, k.String(), fields.MapIndex(k)); err != nil {
+			return NilValue, err
+		}
+	}
+	return p, nil
+}
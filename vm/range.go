@@ -0,0 +1,182 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// for in
+//////////////////////////////
+
+// iterator for in 遍历的状态, 两个引擎共用
+type iterator struct {
+	v     reflect.Value
+	vars  int
+	i     int
+	n     int             // 数组长度, 字符数, 整数的值
+	keys  []reflect.Value // 字典开始遍历时的键
+	runes []rune
+	ctl   *control
+}
+
+// newIterator 数组, 字典, 字符串, 整数和 Go 的 channel 可以遍历
+func newIterator(v reflect.Value, vars int, ctl *control) (*iterator, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	it := &iterator{v: v, vars: vars, ctl: ctl}
+	if isNil(v) {
+		return nil, fmt.Errorf("cannot range over nil")
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		// 长度在开始时确定
+		it.n = v.Len()
+		return it, nil
+	case reflect.Map:
+		it.keys = SortedKeys(v)
+		it.n = len(it.keys)
+		return it, nil
+	case reflect.String:
+		it.runes = []rune(v.String())
+		it.n = len(it.runes)
+		return it, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		it.n = int(v.Int())
+	case reflect.Chan:
+		if v.Type().ChanDir()&reflect.RecvDir == 0 {
+			return nil, fmt.Errorf("cannot range over send-only channel %s", typeName(v))
+		}
+	default:
+		return nil, fmt.Errorf("cannot range over %s", typeName(v))
+	}
+	if vars > 1 {
+		return nil, fmt.Errorf("range over %s permits only one iteration variable", typeName(v))
+	}
+	return it, nil
+}
+
+// next 下一组值, 结束时 ok 为 false.
+// 只有一个变量时 k 是数组, 字符串和 channel 的元素, 字典的键, 和 in 运算一致
+func (it *iterator) next(pos parse.Position) (k, v reflect.Value, ok bool, err error) {
+	k, v, ok, err = it.advance(pos)
+	// 和赋值一样复制取到的值, 变量不引用数组中的元素
+	return letValue(k), letValue(v), ok, err
+}
+
+func (it *iterator) advance(pos parse.Position) (k, v reflect.Value, ok bool, err error) {
+	switch it.v.Kind() {
+	case reflect.Chan:
+		return it.recv(pos)
+	case reflect.Map:
+		// 遍历时删除的键跳过
+		for it.i < it.n {
+			k = it.keys[it.i]
+			it.i++
+			if v = it.v.MapIndex(k); v.IsValid() {
+				if it.vars == 1 {
+					return k, NilValue, true, nil
+				}
+				return k, v, true, nil
+			}
+		}
+		return NilValue, NilValue, false, nil
+	}
+
+	if it.i >= it.n {
+		return NilValue, NilValue, false, nil
+	}
+	i := it.i
+	it.i++
+	k = reflect.ValueOf(int64(i))
+	switch it.v.Kind() {
+	case reflect.Array, reflect.Slice:
+		if i >= it.v.Len() {
+			return NilValue, NilValue, false, nil
+		}
+		v = it.v.Index(i)
+	case reflect.String:
+		v = reflect.ValueOf(string(it.runes[i]))
+	default:
+		return k, NilValue, true, nil
+	}
+	if it.vars == 1 {
+		return v, NilValue, true, nil
+	}
+	return k, v, true, nil
+}
+
+// recv 从 channel 取值直到关闭, 等待时可以被取消
+func (it *iterator) recv(pos parse.Position) (reflect.Value, reflect.Value, bool, error) {
+	var v reflect.Value
+	var ok bool
+	if it.ctl.done == nil {
+		v, ok = it.v.Recv()
+	} else {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: it.v},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(it.ctl.done)},
+		}
+		var chosen int
+		chosen, v, ok = reflect.Select(cases)
+		if chosen == 1 {
+			err := it.ctl.ctx.Err()
+			return NilValue, NilValue, false, &InterruptError{Reason: err.Error(), Err: err, Pos: pos}
+		}
+	}
+	if !ok {
+		return NilValue, NilValue, false, nil
+	}
+	return v, NilValue, true, nil
+}
+
+// define 定义这一轮的变量
+func (it *iterator) define(vars []string, k, v reflect.Value, env *Env) {
+	env.Define(vars[0], k)
+	if len(vars) > 1 {
+		env.Define(vars[1], v)
+	}
+}
+
+// runForIn 遍历的值在外层环境中求值, 变量在整个循环共用的环境中
+func runForIn(stmt *parse.ForInStmt, env *Env) (reflect.Value, error) {
+	c, err := invokeExpr(stmt.Expr, env)
+	if err != nil {
+		return NilValue, NewError(stmt, err)
+	}
+	it, err := newIterator(c, len(stmt.Vars), env.ctl)
+	if err != nil {
+		return NilValue, NewError(stmt.Expr, err)
+	}
+
+	newEnv := env.NewEnv()
+	defer newEnv.Destroy()
+	for {
+		k, v, ok, err := it.next(stmt.Position())
+		if err != nil {
+			return NilValue, err
+		}
+		if !ok {
+			break
+		}
+		it.define(stmt.Vars, k, v, newEnv)
+
+		rv, err := run(stmt.Do, newEnv)
+		if err != nil && err != ContinueError {
+			if err == BreakError {
+				break
+			}
+			if err == ReturnError {
+				return rv, err
+			}
+			return rv, NewError(stmt, err)
+		}
+		if err := newEnv.ctl.step(stmt.Position()); err != nil {
+			return NilValue, err
+		}
+	}
+	return NilValue, nil
+}
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"reflect"
+
+	"github.com/lth-go/gogogo/parse"
+)
+
+//////////////////////////////
+// switch
+//////////////////////////////
+
+// fallsThrough case 的最后一条语句是不是 fallthrough
+func fallsThrough(c *parse.CaseStmt) bool {
+	if len(c.Do) == 0 {
+		return false
+	}
+	_, ok := c.Do[len(c.Do)-1].(*parse.FallthroughStmt)
+	return ok
+}
+
+// matchCase 按顺序比较 case 的值, 都不相等时是 default, 没有 default 时返回 -1
+func matchCase(stmt *parse.SwitchStmt, v reflect.Value, env *Env) (int, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	def := -1
+	for i, c := range stmt.Cases {
+		if c.Exprs == nil {
+			def = i
+			continue
+		}
+		for _, e := range c.Exprs {
+			cv, err := invokeExpr(e, env)
+			if err != nil {
+				return -1, NewError(e, err)
+			}
+			if cv.Kind() == reflect.Interface {
+				cv = cv.Elem()
+			}
+			if equal(v, cv) {
+				return i, nil
+			}
+		}
+	}
+	return def, nil
+}
+
+// runSwitch 每个 case 在自己的环境中执行, fallthrough 时继续执行下一个 case.
+// break, continue 和 if 中一样作用于外面的循环
+func runSwitch(stmt *parse.SwitchStmt, env *Env) (reflect.Value, error) {
+	v, err := invokeExpr(stmt.Expr, env)
+	if err != nil {
+		return v, NewError(stmt, err)
+	}
+	i, err := matchCase(stmt, v, env)
+	if err != nil {
+		return NilValue, err
+	}
+
+	rv := NilValue
+	for ; i >= 0 && i < len(stmt.Cases); i++ {
+		c := stmt.Cases[i]
+		newEnv := env.NewEnv()
+		rv, err = run(c.Do, newEnv)
+		newEnv.Destroy()
+		if err != nil {
+			return rv, NewError(stmt, err)
+		}
+		if !fallsThrough(c) {
+			break
+		}
+	}
+	return rv, nil
+}
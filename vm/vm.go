@@ -1,14 +1,16 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"errors"
 
-	"../parse"
+	"github.com/lth-go/gogogo/parse"
 )
 
 var (
@@ -22,7 +24,6 @@ var (
 	BreakError    = errors.New("Unexpected break statement")
 	ContinueError = errors.New("Unexpected continue statement")
 	ReturnError   = errors.New("Unexpected return statement")
-	//InterruptError = errors.New("Execution interrupted")
 )
 
 //////////////////////////////
@@ -31,17 +32,33 @@ var (
 type Error struct {
 	Message string
 	Pos     parse.Position
+	Trace   []Frame     // 调用栈, 最里层在前, 最后一层是顶层代码
+	Value   interface{} // throw 的值, 运行时错误为 nil
+
+	at      parse.Position // 当前这一层函数中出错的位置
+	pending bool           // 离开函数后还没有记录调用位置
+}
+
+// Frame 调用栈中的一层
+type Frame struct {
+	Func string // 函数名, 匿名函数为 "<anonymous>", 顶层代码为 "main"
+	File string // 源文件, 为空时是主程序
+	Pos  parse.Position
+}
+
+func newError(message string, pos parse.Position) *Error {
+	return &Error{Message: message, Pos: pos, at: pos}
 }
 
 func NewStringError(pos parse.Pos, err string) error {
 	if pos == nil {
-		return &Error{Message: err, Pos: parse.Position{1, 1}}
+		return newError(err, parse.Position{Line: 1, Column: 1})
 	}
-	return &Error{Message: err, Pos: pos.Position()}
+	return newError(err, pos.Position())
 
 }
 func NewErrorf(pos parse.Pos, format string, args ...interface{}) error {
-	return &Error{Message: fmt.Sprintf(format, args...), Pos: pos.Position()}
+	return newError(fmt.Sprintf(format, args...), pos.Position())
 }
 func NewError(pos parse.Pos, err error) error {
 
@@ -51,19 +68,52 @@ func NewError(pos parse.Pos, err error) error {
 	if err == BreakError || err == ContinueError || err == ReturnError {
 		return err
 	}
-	//if pe, ok := err.(*parser.Error); ok {
-	//    return pe
-	//}
+	if _, ok := err.(*InterruptError); ok {
+		return err
+	}
+	// 导入的模块中的语法错误
+	if _, ok := err.(parse.ErrorList); ok {
+		return err
+	}
 	if ee, ok := err.(*Error); ok {
+		ee.callAt(pos.Position())
 		return ee
 	}
-	return &Error{Message: err.Error(), Pos: pos.Position()}
+	return newError(err.Error(), pos.Position())
 }
 func (e *Error) Error() string {
 
 	return e.Message
 }
 
+// leave 错误离开函数时记录这一层调用栈
+func (e *Error) leave(name, file string) {
+	if name == "" {
+		name = "<anonymous>"
+	}
+	e.Trace = append(e.Trace, Frame{Func: name, File: file, Pos: e.at})
+	e.pending = true
+}
+
+// callAt 从函数里出来的错误, 第一个经过的位置就是调用的位置
+func (e *Error) callAt(pos parse.Position) {
+	if e.pending {
+		e.at = pos
+		e.pending = false
+	}
+}
+
+// finishTrace 错误到达顶层时记录顶层代码这一层
+func finishTrace(err error) error {
+	ee, ok := err.(*Error)
+	if !ok || ee.pending {
+		return err
+	}
+	ee.Trace = append(ee.Trace, Frame{Func: "main", Pos: ee.at})
+	ee.pending = true
+	return err
+}
+
 //
 type Func func(args ...reflect.Value) (reflect.Value, error)
 
@@ -78,10 +128,23 @@ func ToFunc(f Func) reflect.Value {
 //////////////////////////////
 // stmt
 //////////////////////////////
+
+// Run 执行语句, 出错时返回带调用栈的 *Error
 func Run(stmts []parse.Stmt, env *Env) (reflect.Value, error) {
+	rv, err := run(stmts, env)
+	return rv, finishTrace(err)
+}
+
+func run(stmts []parse.Stmt, env *Env) (reflect.Value, error) {
 	rv := NilValue
 	var err error
 	for _, stmt := range stmts {
+		if env.ctl.hook != nil {
+			if err := env.ctl.hookStmt(stmt, env); err != nil {
+				return NilValue, err
+			}
+		}
+
 		// 语句
 		rv, err = RunSingleStmt(stmt, env)
 		if err != nil {
@@ -138,10 +201,11 @@ func RunSingleStmt(stmt parse.Stmt, env *Env) (reflect.Value, error) {
 			}
 			_, err = invokeLetExpr(lhs, v, env)
 			if err != nil {
-				return rvs, NewError(lhs, err)
+				return NilValue, NewError(lhs, err)
 			}
 		}
-		return rvs, nil
+		// 赋值语句没有值
+		return NilValue, nil
 	case *parse.IfStmt:
 		rv, err := invokeExpr(stmt.Condition, env)
 		if err != nil {
@@ -151,7 +215,7 @@ func RunSingleStmt(stmt parse.Stmt, env *Env) (reflect.Value, error) {
 		if toBool(rv) {
 			newEnv := env.NewEnv()
 			defer newEnv.Destroy()
-			rv, err = Run(stmt.Do, newEnv)
+			rv, err = run(stmt.Do, newEnv)
 			if err != nil {
 				return rv, NewError(stmt, err)
 			}
@@ -171,7 +235,7 @@ func RunSingleStmt(stmt parse.Stmt, env *Env) (reflect.Value, error) {
 				}
 				// 成功
 				done = true
-				rv, err = Run(stmtIf.Do, env)
+				rv, err = run(stmtIf.Do, env)
 				if err != nil {
 					return rv, NewError(stmt, err)
 				}
@@ -182,57 +246,95 @@ func RunSingleStmt(stmt parse.Stmt, env *Env) (reflect.Value, error) {
 			// Else
 			newEnv := env.NewEnv()
 			defer newEnv.Destroy()
-			rv, err = Run(stmt.Else, newEnv)
+			rv, err = run(stmt.Else, newEnv)
 			if err != nil {
 				return rv, NewError(stmt, err)
 			}
+		} else if !done {
+			// 没有执行的语句块
+			rv = NilValue
 		}
 		return rv, nil
 	case *parse.ForStmt:
 		newEnv := env.NewEnv()
 		defer newEnv.Destroy()
-		_, err := invokeExpr(stmt.Initial, newEnv)
-		if err != nil {
-			return NilValue, err
-		}
-		for {
-			fb, err := invokeExpr(stmt.Condition, newEnv)
+		// 省略的部分为 nil, 省略条件时一直循环
+		if stmt.Initial != nil {
+			_, err := invokeExpr(stmt.Initial, newEnv)
 			if err != nil {
 				return NilValue, err
 			}
-			if !toBool(fb) {
-				break
+		}
+		for {
+			if stmt.Condition != nil {
+				fb, err := invokeExpr(stmt.Condition, newEnv)
+				if err != nil {
+					return NilValue, err
+				}
+				if !toBool(fb) {
+					break
+				}
 			}
 
-			rv, err := Run(stmt.Do, newEnv)
-			if err != nil {
+			rv, err := run(stmt.Do, newEnv)
+			if err != nil && err != ContinueError {
 				if err == BreakError {
 					err = nil
 					break
 				}
-				if err == ContinueError {
-					err = nil
-					continue
-				}
 				if err == ReturnError {
 					return rv, err
 				}
 				return rv, NewError(stmt, err)
 			}
-			_, err = invokeExpr(stmt.After, newEnv)
-			if err != nil {
+			// continue 之后也要执行 After
+			if stmt.After != nil {
+				_, err = invokeExpr(stmt.After, newEnv)
+				if err != nil {
+					return NilValue, err
+				}
+			}
+			if err := newEnv.ctl.step(stmt.Position()); err != nil {
 				return NilValue, err
 			}
 		}
 		return NilValue, nil
+	case *parse.ForInStmt:
+		return runForIn(stmt, env)
 	case *parse.ReturnStmt:
 		//rvs := []interface{}{}
 		// TODO 单个返回值
+		if stmt.Expr == nil {
+			return NilValue, nil
+		}
 		rv, err := invokeExpr(stmt.Expr, env)
 		if err != nil {
 			return rv, NewError(stmt, err)
 		}
 		return rv, nil
+	case *parse.BreakStmt, *parse.ContinueStmt:
+		// 由 Run 返回 BreakError, ContinueError
+		return NilValue, nil
+	case *parse.SwitchStmt:
+		return runSwitch(stmt, env)
+	case *parse.FallthroughStmt:
+		// 由 runSwitch 处理
+		return NilValue, nil
+	case *parse.TryStmt:
+		return runTry(stmt, env)
+	case *parse.ThrowStmt:
+		rv, err := invokeExpr(stmt.Expr, env)
+		if err != nil {
+			return rv, NewError(stmt, err)
+		}
+		return NilValue, throwError(rv, stmt.Position())
+	case *parse.ImportStmt:
+		m, err := importModule(stmt, env, run)
+		if err != nil {
+			return NilValue, NewError(stmt, err)
+		}
+		env.Define(stmt.Name, m)
+		return NilValue, nil
 	default:
 		return NilValue, NewStringError(stmt, "unknown statement")
 	}
@@ -247,6 +349,28 @@ func invokeLetExpr(expr parse.Expr, rv reflect.Value, env *Env) (reflect.Value,
 			env.Define(lhs.Lit, rv)
 		}
 		return rv, nil
+	case *parse.IndexExpr:
+		v, err := invokeExpr(lhs.Value, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		i, err := invokeExpr(lhs.Index, env)
+		if err != nil {
+			return i, NewError(expr, err)
+		}
+		if err := setIndex(v, i, rv); err != nil {
+			return NilValue, NewError(expr, err)
+		}
+		return rv, nil
+	case *parse.MemberExpr:
+		v, err := invokeExpr(lhs.Expr, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		if err := setMember(v, lhs.Name, rv); err != nil {
+			return NilValue, NewError(expr, err)
+		}
+		return rv, nil
 	default:
 	}
 	return NilValue, NewStringError(expr, "Invalid operation")
@@ -258,25 +382,29 @@ func invokeLetExpr(expr parse.Expr, rv reflect.Value, env *Env) (reflect.Value,
 func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 	switch e := expr.(type) {
 	case *parse.NumberExpr:
-		// 浮点数
-		if strings.Contains(e.Lit, ".") {
-			v, err := strconv.ParseFloat(e.Lit, 64)
-			if err != nil {
-				return NilValue, NewError(expr, err)
-			}
-			return reflect.ValueOf(float64(v)), nil
-		}
-		// 整数
-		i, err := strconv.ParseInt(e.Lit, 10, 64)
-
+		v, err := parseNumber(e.Lit)
 		if err != nil {
 			return NilValue, NewError(expr, err)
 		}
-		return reflect.ValueOf(i), nil
+		return reflect.ValueOf(v), nil
 	case *parse.IdentExpr:
-		return env.Get(e.Lit)
+		v, err := env.Get(e.Lit)
+		return v, NewError(expr, err)
 	case *parse.StringExpr:
 		return reflect.ValueOf(e.Lit), nil
+	case *parse.InterpExpr:
+		var buf bytes.Buffer
+		for i, lit := range e.Lits {
+			buf.WriteString(lit)
+			if i < len(e.Exprs) {
+				v, err := invokeExpr(e.Exprs[i], env)
+				if err != nil {
+					return v, NewError(e.Exprs[i], err)
+				}
+				buf.WriteString(toString(v))
+			}
+		}
+		return reflect.ValueOf(buf.String()), nil
 	case *parse.ParenExpr:
 		v, err := invokeExpr(e.SubExpr, env)
 		if err != nil {
@@ -284,21 +412,37 @@ func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 		}
 		return v, nil
 	case *parse.FuncExpr:
+		// 闭包引用的环境不能被销毁
+		env.capture()
 		f := reflect.ValueOf(func(expr *parse.FuncExpr, env *Env) Func {
 			return func(args ...reflect.Value) (reflect.Value, error) {
+				if err := env.ctl.enter(expr.Name, expr.File, expr.Position()); err != nil {
+					return NilValue, err
+				}
+				defer env.ctl.leave()
 				newenv := env.NewEnv()
+				// 和字节码一样, 少传的参数为 nil, 多传的忽略
 				for i, arg := range expr.Args {
-					newenv.Define(arg, args[i])
+					if i < len(args) {
+						newenv.Define(arg, args[i])
+					} else {
+						newenv.Define(arg, NilValue)
+					}
 				}
-				rr, err := Run(expr.Stmts, newenv)
+				rr, err := run(expr.Stmts, newenv)
 				if err == ReturnError {
 					err = nil
 					rr = rr.Interface().(reflect.Value)
 				}
+				if ee, ok := err.(*Error); ok {
+					ee.leave(expr.Name, expr.File)
+				}
 				return rr, err
 			}
 		}(e, env))
-		env.Define(e.Name, f)
+		if e.Name != "" {
+			env.Define(e.Name, f)
+		}
 		return f, nil
 	case *parse.LetsExpr:
 		rv := NilValue
@@ -330,6 +474,16 @@ func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 			}
 		}
 		return rvs, nil
+	case *parse.UnaryExpr:
+		v, err := invokeExpr(e.Expr, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		v, err = evalUnaryOp(e.Operator, v)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		return v, nil
 	case *parse.BinOpExpr:
 		lhsV := NilValue
 		rhsV := NilValue
@@ -351,67 +505,86 @@ func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 				rhsV = rhsV.Elem()
 			}
 		}
-		switch e.Operator {
-		case "+":
-			if lhsV.Kind() == reflect.String || rhsV.Kind() == reflect.String {
-				return reflect.ValueOf(toString(lhsV) + toString(rhsV)), nil
-			}
-			if (lhsV.Kind() == reflect.Array || lhsV.Kind() == reflect.Slice) && (rhsV.Kind() != reflect.Array && rhsV.Kind() != reflect.Slice) {
-				return reflect.Append(lhsV, rhsV), nil
-			}
-			if (lhsV.Kind() == reflect.Array || lhsV.Kind() == reflect.Slice) && (rhsV.Kind() == reflect.Array || rhsV.Kind() == reflect.Slice) {
-				return reflect.AppendSlice(lhsV, rhsV), nil
-			}
-			if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
-				return reflect.ValueOf(toFloat64(lhsV) + toFloat64(rhsV)), nil
-			}
-			return reflect.ValueOf(toInt64(lhsV) + toInt64(rhsV)), nil
-		case "-":
-			if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
-				return reflect.ValueOf(toFloat64(lhsV) - toFloat64(rhsV)), nil
-			}
-			return reflect.ValueOf(toInt64(lhsV) - toInt64(rhsV)), nil
-		case "*":
-			if lhsV.Kind() == reflect.String && (rhsV.Kind() == reflect.Int || rhsV.Kind() == reflect.Int32 || rhsV.Kind() == reflect.Int64) {
-				return reflect.ValueOf(strings.Repeat(toString(lhsV), int(toInt64(rhsV)))), nil
-			}
-			if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
-				return reflect.ValueOf(toFloat64(lhsV) * toFloat64(rhsV)), nil
-			}
-			return reflect.ValueOf(toInt64(lhsV) * toInt64(rhsV)), nil
-		case "/":
-			return reflect.ValueOf(toFloat64(lhsV) / toFloat64(rhsV)), nil
-		case "%":
-			return reflect.ValueOf(toInt64(lhsV) % toInt64(rhsV)), nil
-		case "==":
-			return reflect.ValueOf(equal(lhsV, rhsV)), nil
-		case "!=":
-			return reflect.ValueOf(equal(lhsV, rhsV) == false), nil
-		case ">":
-			return reflect.ValueOf(toFloat64(lhsV) > toFloat64(rhsV)), nil
-		case ">=":
-			return reflect.ValueOf(toFloat64(lhsV) >= toFloat64(rhsV)), nil
-		case "<":
-			return reflect.ValueOf(toFloat64(lhsV) < toFloat64(rhsV)), nil
-		case "<=":
-			return reflect.ValueOf(toFloat64(lhsV) <= toFloat64(rhsV)), nil
-		case "|":
-			return reflect.ValueOf(toInt64(lhsV) | toInt64(rhsV)), nil
-		case "||":
-			if toBool(lhsV) {
-				return lhsV, nil
+		v, err := evalBinOp(e.Operator, lhsV, rhsV)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		return v, nil
+	case *parse.ArrayExpr:
+		a := make([]interface{}, len(e.Exprs))
+		for i, expr := range e.Exprs {
+			v, err := invokeExpr(expr, env)
+			if err != nil {
+				return v, NewError(expr, err)
 			}
-			return rhsV, nil
-		case "&":
-			return reflect.ValueOf(toInt64(lhsV) & toInt64(rhsV)), nil
-		case "&&":
-			if toBool(lhsV) {
-				return rhsV, nil
+			if v.IsValid() && v.CanInterface() {
+				a[i] = v.Interface()
+			}
+		}
+		return reflect.ValueOf(a), nil
+	case *parse.MapExpr:
+		m := make(map[interface{}]interface{}, len(e.Keys))
+		for i, key := range e.Keys {
+			k, err := invokeExpr(key, env)
+			if err != nil {
+				return k, NewError(key, err)
+			}
+			v, err := invokeExpr(e.Values[i], env)
+			if err != nil {
+				return v, NewError(e.Values[i], err)
+			}
+			if err := setIndex(reflect.ValueOf(m), k, v); err != nil {
+				return NilValue, NewError(key, err)
+			}
+		}
+		return reflect.ValueOf(m), nil
+	case *parse.IndexExpr:
+		v, err := invokeExpr(e.Value, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		i, err := invokeExpr(e.Index, env)
+		if err != nil {
+			return i, NewError(expr, err)
+		}
+		v, err = indexValue(v, i)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		return v, nil
+	case *parse.MemberExpr:
+		v, err := invokeExpr(e.Expr, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		v, err = member(v, e.Name)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		return v, nil
+	case *parse.SliceExpr:
+		v, err := invokeExpr(e.Value, env)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		begin, end := NilValue, NilValue
+		if e.Begin != nil {
+			begin, err = invokeExpr(e.Begin, env)
+			if err != nil {
+				return begin, NewError(expr, err)
 			}
-			return lhsV, nil
-		default:
-			return NilValue, NewStringError(expr, "Unknown operator")
 		}
+		if e.End != nil {
+			end, err = invokeExpr(e.End, env)
+			if err != nil {
+				return end, NewError(expr, err)
+			}
+		}
+		v, err = sliceValue(v, begin, end, e.Begin != nil, e.End != nil)
+		if err != nil {
+			return v, NewError(expr, err)
+		}
+		return v, nil
 	case *parse.ConstExpr:
 		switch e.Value {
 		case "true":
@@ -425,7 +598,11 @@ func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 
 		// 判断是否是匿名函数
 		if e.Func != nil {
-			f = e.Func.(reflect.Value)
+			ff, err := invokeExpr(e.Func, env)
+			if err != nil {
+				return ff, NewError(expr, err)
+			}
+			f = ff
 		} else {
 			// 奇怪的写法
 			ff, err := env.Get(e.Name)
@@ -435,76 +612,543 @@ func invokeExpr(expr parse.Expr, env *Env) (reflect.Value, error) {
 			f = ff
 		}
 
-		// 需要研究反射
-		_, isReflect := f.Interface().(Func)
-		// 形参赋值
+		// 实参
 		args := []reflect.Value{}
-		for i, expr := range e.SubExprs {
+		for _, expr := range e.SubExprs {
 			arg, err := invokeExpr(expr, env)
 			if err != nil {
 				return arg, NewError(expr, err)
 			}
+			args = append(args, arg)
+		}
+		if err := env.ctl.step(expr.Position()); err != nil {
+			return NilValue, err
+		}
+		ret, err := callFunc(f, args)
+		if err != nil {
+			return ret, NewError(expr, err)
+		}
+		return ret, nil
+	default:
+		return NilValue, NewStringError(expr, "为止的表达式")
+	}
+}
 
-			if i < f.Type().NumIn() {
-				it := f.Type().In(i)
+//////////////////////////////
+// utils
+//////////////////////////////
 
-				if arg.Kind() != it.Kind() && arg.IsValid() && arg.Type().ConvertibleTo(it) {
-					arg = arg.Convert(it)
-				} else if !arg.IsValid() {
-					arg = reflect.Zero(it)
-				}
+// Call 调用函数值, 脚本函数和 Go 函数都可以
+func Call(f reflect.Value, args ...reflect.Value) (reflect.Value, error) {
+	return callFunc(f, args)
+}
+
+// callFunc 调用函数, 按形参类型转换实参, 调用类型时构造这个类型的值
+func callFunc(f reflect.Value, args []reflect.Value) (reflect.Value, error) {
+	if f.Kind() == reflect.Interface {
+		f = f.Elem()
+	}
+	// 调用类型是构造或转换
+	if t, ok := typeValue(f); ok {
+		return construct(t, args)
+	}
+	if f.Kind() != reflect.Func {
+		return NilValue, fmt.Errorf("cannot call non-function value of type %s", typeName(f))
+	}
+	// 需要研究反射
+	fn, isReflect := f.Interface().(Func)
+	if !isReflect {
+		return callGo(f, args)
+	}
+	// 脚本函数和内置函数的参数都是 reflect.Value, 不用转换, nil 仍然是 nil
+	for i, arg := range args {
+		if arg.Kind() == reflect.Interface {
+			arg = arg.Elem()
+		}
+		if !arg.IsValid() {
+			arg = NilValue
+		}
+		args[i] = arg
+	}
+
+	// 脚本函数直接调用
+	return fn(args...)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callGo 调用 Go 函数, 参数个数不对或者类型不能转换时报错
+func callGo(f reflect.Value, args []reflect.Value) (reflect.Value, error) {
+	t := f.Type()
+	n := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < n-1 {
+			return NilValue, fmt.Errorf("not enough arguments in call to %s: have %d, want at least %d", t, len(args), n-1)
+		}
+	} else if len(args) != n {
+		return NilValue, fmt.Errorf("wrong number of arguments in call to %s: have %d, want %d", t, len(args), n)
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		// 可变参数按元素类型转换
+		var it reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			it = t.In(n - 1).Elem()
+		} else {
+			it = t.In(i)
+		}
+		v, err := convertTo(arg, it)
+		if err != nil {
+			return NilValue, fmt.Errorf("argument %d in call to %s: %v", i+1, t, err)
+		}
+		in[i] = v
+	}
+
+	rets := f.Call(in)
+	// 最后一个返回值是 error 时, 不为 nil 就作为脚本的错误, 否则去掉
+	if n := len(rets); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := rets[n-1].Interface().(error); err != nil {
+			return NilValue, err
+		}
+		rets = rets[:n-1]
+	}
+	switch len(rets) {
+	case 0:
+		return NilValue, nil
+	case 1:
+		return rets[0], nil
+	}
+	var result []interface{}
+	for _, r := range rets {
+		result = append(result, r.Interface())
+	}
+	return reflect.ValueOf(result), nil
+}
+
+// parseNumber 把数字字面量转换为 int64 或 float64, 超出范围时报错
+func parseNumber(lit string) (interface{}, error) {
+	hex := strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X")
+	if !hex && strings.ContainsAny(lit, ".eE") {
+		f, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, fmt.Errorf("floating-point literal %s overflows float64", lit)
+			}
+			return nil, fmt.Errorf("invalid floating-point literal %s", lit)
+		}
+		return f, nil
+	}
+	// 进制为 0 时按前缀判断, 并允许 _
+	i, err := strconv.ParseInt(lit, 0, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("integer literal %s overflows int64", lit)
+		}
+		return nil, fmt.Errorf("invalid integer literal %s", lit)
+	}
+	return i, nil
+}
+
+// toIndex 下标必须是整数
+func toIndex(v reflect.Value) (int, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), nil
+	}
+	return 0, fmt.Errorf("index should be int, not %s", typeName(v))
+}
+
+// indexValue 取数组或字符串的元素, 字符串按字符取
+func indexValue(v, index reflect.Value) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		i, err := toIndex(index)
+		if err != nil {
+			return NilValue, err
+		}
+		if i < 0 || i >= v.Len() {
+			return NilValue, fmt.Errorf("index %d out of range [0:%d]", i, v.Len())
+		}
+		r := v.Index(i)
+		if r.Kind() == reflect.Interface {
+			r = r.Elem()
+		}
+		return r, nil
+	case reflect.Map:
+		k, err := convertTo(index, v.Type().Key())
+		if err != nil {
+			return NilValue, err
+		}
+		// 不存在的键返回 nil
+		r := v.MapIndex(k)
+		if r.Kind() == reflect.Interface {
+			r = r.Elem()
+		}
+		return r, nil
+	case reflect.String:
+		i, err := toIndex(index)
+		if err != nil {
+			return NilValue, err
+		}
+		runes := []rune(v.String())
+		if i < 0 || i >= len(runes) {
+			return NilValue, fmt.Errorf("index %d out of range [0:%d]", i, len(runes))
+		}
+		return reflect.ValueOf(string(runes[i])), nil
+	}
+	return NilValue, fmt.Errorf("type %s does not support index operation", typeName(v))
+}
+
+// sliceValue 切片, 省略的 begin, end 分别为开头和结尾
+func sliceValue(v, begin, end reflect.Value, hasBegin, hasEnd bool) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	var runes []rune
+	var length int
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		length = v.Len()
+	case reflect.String:
+		runes = []rune(v.String())
+		length = len(runes)
+	default:
+		return NilValue, fmt.Errorf("type %s does not support slice operation", typeName(v))
+	}
+
+	b, e := 0, length
+	var err error
+	if hasBegin {
+		if b, err = toIndex(begin); err != nil {
+			return NilValue, err
+		}
+	}
+	if hasEnd {
+		if e, err = toIndex(end); err != nil {
+			return NilValue, err
+		}
+	}
+	if b < 0 || e > length || b > e {
+		return NilValue, fmt.Errorf("slice bounds out of range [%d:%d] with length %d", b, e, length)
+	}
+
+	if v.Kind() == reflect.String {
+		return reflect.ValueOf(string(runes[b:e])), nil
+	}
+	return v.Slice(b, e), nil
+}
+
+// setIndex 给数组元素赋值
+func setIndex(v, index, rv reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+		i, err := toIndex(index)
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= v.Len() {
+			return fmt.Errorf("index %d out of range [0:%d]", i, v.Len())
+		}
+		elem := v.Index(i)
+		if !elem.CanSet() {
+			return errors.New("array element cannot be assigned")
+		}
+		val, err := convertTo(rv, elem.Type())
+		if err != nil {
+			return err
+		}
+		elem.Set(val)
+		return nil
+	case reflect.Map:
+		if v.IsNil() {
+			return errors.New("assignment to entry in nil map")
+		}
+		k, err := convertTo(index, v.Type().Key())
+		if err != nil {
+			return err
+		}
+		val, err := convertTo(rv, v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(k, val)
+		return nil
+	}
+	return fmt.Errorf("type %s does not support index assignment", typeName(v))
+}
+
+// ConvertTo 把值转换为可以赋给 t 类型的值, 规则和给 Go 函数传参相同
+func ConvertTo(rv reflect.Value, t reflect.Type) (reflect.Value, error) {
+	return convertTo(rv, t)
+}
+
+// convertTo 把值转换为可以赋给 t 类型的值, 脚本的数组和字典按元素转换
+func convertTo(rv reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv == NilValue {
+		return reflect.Zero(t), nil
+	}
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	}
+	switch {
+	case t.Kind() == reflect.Slice && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array):
+		s := reflect.MakeSlice(t, rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			v, err := convertTo(rv.Index(i), t.Elem())
+			if err != nil {
+				return NilValue, fmt.Errorf("cannot use %s as %s: element %d: %v", typeName(rv), t, i, err)
 			}
-			if !arg.IsValid() {
-				arg = NilValue
+			s.Index(i).Set(v)
+		}
+		return s, nil
+	case t.Kind() == reflect.Map && rv.Kind() == reflect.Map:
+		m := reflect.MakeMapWithSize(t, rv.Len())
+		for _, k := range rv.MapKeys() {
+			ck, err := convertTo(k, t.Key())
+			if err != nil {
+				return NilValue, fmt.Errorf("cannot use %s as %s: key: %v", typeName(rv), t, err)
+			}
+			cv, err := convertTo(rv.MapIndex(k), t.Elem())
+			if err != nil {
+				return NilValue, fmt.Errorf("cannot use %s as %s: value: %v", typeName(rv), t, err)
 			}
+			m.SetMapIndex(ck, cv)
+		}
+		return m, nil
+	case (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && t.Kind() != reflect.String:
+		// 切片转换为数组或数组指针时长度不对会 panic, 不转换
+	case t.Kind() == reflect.String && isInt(rv):
+		// Go 的转换把整数当作字符, 不转换
+	default:
+		if rv.Type().ConvertibleTo(t) {
+			return rv.Convert(t), nil
+		}
+	}
+	return NilValue, fmt.Errorf("cannot use %s as %s", typeName(rv), t)
+}
 
-			if !isReflect {
-				args = append(args, arg)
-			} else {
-				if arg.Kind() == reflect.Interface {
-					arg = arg.Elem()
-				}
-				args = append(args, reflect.ValueOf(arg))
+// contains in 运算, 字典判断键, 数组判断元素, 字符串判断子串
+func contains(container, v reflect.Value) (bool, error) {
+	if container.Kind() == reflect.Interface {
+		container = container.Elem()
+	}
+	switch container.Kind() {
+	case reflect.Map:
+		k, err := convertTo(v, container.Type().Key())
+		if err != nil {
+			return false, nil
+		}
+		return container.MapIndex(k).IsValid(), nil
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < container.Len(); i++ {
+			elem := container.Index(i)
+			if elem.Kind() == reflect.Interface {
+				elem = elem.Elem()
+			}
+			if equal(elem, v) {
+				return true, nil
 			}
 		}
-		ret := NilValue
-		var err error
-		fnc := func() {
-			if f.Kind() == reflect.Interface {
-				f = f.Elem()
-			}
-			rets := f.Call(args)
-			if isReflect {
-				ev := rets[1].Interface()
-				if ev != nil {
-					err = ev.(error)
-				}
-				ret = rets[0].Interface().(reflect.Value)
-			} else {
-				if f.Type().NumOut() == 1 {
-					ret = rets[0]
-				} else {
-					var result []interface{}
-					for _, r := range rets {
-						result = append(result, r.Interface())
-					}
-					ret = reflect.ValueOf(result)
-				}
+		return false, nil
+	case reflect.String:
+		return strings.Contains(container.String(), toString(v)), nil
+	}
+	return false, fmt.Errorf("type %s does not support in operation", typeName(container))
+}
+
+// SortedKeys 返回排序后的字典键, 保证遍历字典的顺序固定:
+// nil 在最前, 然后依次是 bool, 数字(按大小), 字符串(按字典序), 其他类型按打印结果排序
+func SortedKeys(m reflect.Value) []reflect.Value {
+	if m.Kind() == reflect.Interface {
+		m = m.Elem()
+	}
+	keys := m.MapKeys()
+	for i, k := range keys {
+		if k.Kind() == reflect.Interface {
+			keys[i] = k.Elem()
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+func keyRank(v reflect.Value) int {
+	switch {
+	case isNil(v):
+		return 0
+	case v.Kind() == reflect.Bool:
+		return 1
+	case isNum(v):
+		return 2
+	case v.Kind() == reflect.String:
+		return 3
+	}
+	return 4
+}
+
+func lessKey(a, b reflect.Value) bool {
+	ra, rb := keyRank(a), keyRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	switch ra {
+	case 1:
+		return !a.Bool() && b.Bool()
+	case 2:
+		return toFloat64(a) < toFloat64(b)
+	case 3:
+		return a.String() < b.String()
+	case 4:
+		return toString(a) < toString(b)
+	}
+	return false
+}
+
+// typeName 错误信息中的类型名
+func typeName(v reflect.Value) string {
+	if !v.IsValid() || v == NilValue {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+// evalUnaryOp 一元运算, 只支持数字取正负, bool 取反, 整数按位取反
+func evalUnaryOp(op string, v reflect.Value) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch op {
+	case "+", "-":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if op == "-" {
+				return reflect.ValueOf(-v.Int()), nil
 			}
+			return reflect.ValueOf(v.Int()), nil
+		case reflect.Float32, reflect.Float64:
+			if op == "-" {
+				return reflect.ValueOf(-v.Float()), nil
+			}
+			return reflect.ValueOf(v.Float()), nil
+		}
+	case "!":
+		if v.Kind() == reflect.Bool {
+			return reflect.ValueOf(!v.Bool()), nil
+		}
+	case "^":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflect.ValueOf(^v.Int()), nil
+		}
+	default:
+		return NilValue, errors.New("Unknown operator")
+	}
+	return NilValue, fmt.Errorf("invalid operation: operator %s not defined on %s", op, typeName(v))
+}
+
+// evalBinOp 二元运算
+func evalBinOp(op string, lhsV, rhsV reflect.Value) (reflect.Value, error) {
+	switch op {
+	case "+":
+		if lhsV.Kind() == reflect.String || rhsV.Kind() == reflect.String {
+			return reflect.ValueOf(toString(lhsV) + toString(rhsV)), nil
+		}
+		if (lhsV.Kind() == reflect.Array || lhsV.Kind() == reflect.Slice) && (rhsV.Kind() != reflect.Array && rhsV.Kind() != reflect.Slice) {
+			return reflect.Append(lhsV, rhsV), nil
+		}
+		if (lhsV.Kind() == reflect.Array || lhsV.Kind() == reflect.Slice) && (rhsV.Kind() == reflect.Array || rhsV.Kind() == reflect.Slice) {
+			return reflect.AppendSlice(lhsV, rhsV), nil
+		}
+		if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
+			return reflect.ValueOf(toFloat64(lhsV) + toFloat64(rhsV)), nil
 		}
-		fnc()
+		return reflect.ValueOf(toInt64(lhsV) + toInt64(rhsV)), nil
+	case "-":
+		if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
+			return reflect.ValueOf(toFloat64(lhsV) - toFloat64(rhsV)), nil
+		}
+		return reflect.ValueOf(toInt64(lhsV) - toInt64(rhsV)), nil
+	case "*":
+		if lhsV.Kind() == reflect.String && (rhsV.Kind() == reflect.Int || rhsV.Kind() == reflect.Int32 || rhsV.Kind() == reflect.Int64) {
+			return reflect.ValueOf(strings.Repeat(toString(lhsV), int(toInt64(rhsV)))), nil
+		}
+		if lhsV.Kind() == reflect.Float64 || rhsV.Kind() == reflect.Float64 {
+			return reflect.ValueOf(toFloat64(lhsV) * toFloat64(rhsV)), nil
+		}
+		return reflect.ValueOf(toInt64(lhsV) * toInt64(rhsV)), nil
+	case "/":
+		return reflect.ValueOf(toFloat64(lhsV) / toFloat64(rhsV)), nil
+	case "%":
+		if toInt64(rhsV) == 0 {
+			return NilValue, errors.New("integer divide by zero")
+		}
+		return reflect.ValueOf(toInt64(lhsV) % toInt64(rhsV)), nil
+	case "==":
+		return reflect.ValueOf(equal(lhsV, rhsV)), nil
+	case "!=":
+		return reflect.ValueOf(equal(lhsV, rhsV) == false), nil
+	case ">":
+		return reflect.ValueOf(toFloat64(lhsV) > toFloat64(rhsV)), nil
+	case ">=":
+		return reflect.ValueOf(toFloat64(lhsV) >= toFloat64(rhsV)), nil
+	case "<":
+		return reflect.ValueOf(toFloat64(lhsV) < toFloat64(rhsV)), nil
+	case "<=":
+		return reflect.ValueOf(toFloat64(lhsV) <= toFloat64(rhsV)), nil
+	case "|":
+		return reflect.ValueOf(toInt64(lhsV) | toInt64(rhsV)), nil
+	case "||":
+		if toBool(lhsV) {
+			return lhsV, nil
+		}
+		return rhsV, nil
+	case "&":
+		return reflect.ValueOf(toInt64(lhsV) & toInt64(rhsV)), nil
+	case "&&":
+		if toBool(lhsV) {
+			return rhsV, nil
+		}
+		return lhsV, nil
+	case "in":
+		ok, err := contains(rhsV, lhsV)
 		if err != nil {
-			return ret, NewError(expr, err)
+			return NilValue, err
 		}
-		return ret, nil
+		return reflect.ValueOf(ok), nil
 	default:
-		return NilValue, NewStringError(expr, "为止的表达式")
+		return NilValue, errors.New("Unknown operator")
 	}
 }
 
-//////////////////////////////
-// utils
-//////////////////////////////
+// Less 排序用的顺序, 和 SortedKeys 相同
+func Less(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	return lessKey(a, b)
+}
+
+// ToString 转换为字符串, 规则和字符串拼接相同
+func ToString(v reflect.Value) string {
+	return toString(v)
+}
 
 func toString(v reflect.Value) string {
 	if v.Kind() == reflect.Interface {
@@ -513,9 +1157,38 @@ func toString(v reflect.Value) string {
 	if v.Kind() == reflect.String {
 		return v.String()
 	}
-	if !v.IsValid() {
+	// NilValue 和数组中的 nil 都是 nil
+	if !v.IsValid() || v.Type() == NilType && v.IsNil() {
 		return "nil"
 	}
+	// 数组的元素按同样的规则转换
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Interface {
+		var buf bytes.Buffer
+		buf.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(toString(v.Index(i)))
+		}
+		buf.WriteString("]")
+		return buf.String()
+	}
+	// 字典按 SortedKeys 的顺序输出
+	if v.Kind() == reflect.Map {
+		var buf bytes.Buffer
+		buf.WriteString("map[")
+		for i, k := range SortedKeys(v) {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(toString(k))
+			buf.WriteString(":")
+			buf.WriteString(toString(v.MapIndex(k)))
+		}
+		buf.WriteString("]")
+		return buf.String()
+	}
 	return fmt.Sprint(v.Interface())
 
 }
@@ -565,6 +1238,15 @@ func isNil(v reflect.Value) bool {
 	return false
 }
 
+// isInt 有符号和无符号整数
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
 func isNum(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
@@ -582,6 +1264,10 @@ func equal(lhsV, rhsV reflect.Value) bool {
 	if (!lhsIsNil && rhsIsNil) || (lhsIsNil && !rhsIsNil) {
 		return false
 	}
+	// 比较值本身, reflect.Value 中还有指针和标记
+	if lhsV.CanInterface() && rhsV.CanInterface() {
+		return reflect.DeepEqual(lhsV.Interface(), rhsV.Interface())
+	}
 	return reflect.DeepEqual(lhsV, rhsV)
 }
 func toInt64(v reflect.Value) int64 {